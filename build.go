@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// buildFile compiles a script and writes the bytecode to output, which defaults to
+// the script name with the .mkc extension
+func buildFile(filename, output string, errOut io.Writer) int {
+	program, ok := parseFile(filename, errOut)
+	if !ok {
+		return 1
+	}
+
+	bytecode, ok := compileScript(program, errOut)
+	if !ok {
+		return 1
+	}
+
+	data, err := bytecode.MarshalBinary()
+	if err != nil {
+		fmt.Fprintf(errOut, "could not serialize %s: %s\n", filename, err)
+		return 1
+	}
+
+	if output == "" {
+		output = strings.TrimSuffix(filename, ".mk") + ".mkc"
+	}
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		fmt.Fprintf(errOut, "could not write %s: %s\n", output, err)
+		return 1
+	}
+	return 0
+}
@@ -4,12 +4,27 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"monkey-int/token"
 )
 
 type Opcode byte
 
 type Instructions []byte
 
+// SourceMap maps instruction offsets to the source position they were compiled from
+type SourceMap map[int]token.Position
+
+// PositionAt returns the position of the instruction at offset ip, or of the closest preceding
+// instruction that has a position
+func (sm SourceMap) PositionAt(ip int) token.Position {
+	for i := ip; i >= 0; i-- {
+		if pos, ok := sm[i]; ok {
+			return pos
+		}
+	}
+	return token.Position{}
+}
+
 const (
 	OpConstant      Opcode = 0x01
 	OpPop           Opcode = 0x02
@@ -19,6 +34,14 @@ const (
 	OpDiv           Opcode = 0x06
 	OpMinus         Opcode = 0x07
 	OpBang          Opcode = 0x08
+	OpMod           Opcode = 0x09
+	OpBitAnd        Opcode = 0x0A
+	OpBitOr         Opcode = 0x0B
+	OpBitXor        Opcode = 0x0C
+	OpShiftLeft     Opcode = 0x0D
+	OpShiftRight    Opcode = 0x0E
+	OpBitNot        Opcode = 0x0F
+	OpDup           Opcode = 0x10
 	OpFalse         Opcode = 0xA0
 	OpTrue          Opcode = 0xA1
 	OpNull          Opcode = 0xA2
@@ -26,16 +49,32 @@ const (
 	OpNotEqual      Opcode = 0xB1
 	OpGreaterThan   Opcode = 0xB2
 	OpLessThan      Opcode = 0xB3
+	OpGreaterEqual  Opcode = 0xB4
+	OpLessEqual     Opcode = 0xB5
 	OpJumpNotTruthy Opcode = 0xC0
 	OpJump          Opcode = 0xC1
 	OpGetGlobal     Opcode = 0xD0
 	OpSetGlobal     Opcode = 0xD1
+	OpGetLocal      Opcode = 0xD2
+	OpSetLocal      Opcode = 0xD3
+	OpGetFree       Opcode = 0xD4
+	OpGetBuiltin    Opcode = 0xD5
+	OpSetFree       Opcode = 0xD6
+	OpCaptureLocal  Opcode = 0xD7
+	OpCaptureFree   Opcode = 0xD8
 	OpArray         Opcode = 0xE0
 	OpHash          Opcode = 0xE1
 	OpIndex         Opcode = 0xE2
+	OpIter          Opcode = 0xE3
+	OpSetIndex      Opcode = 0xE4
+	OpToString      Opcode = 0xE5
+	OpConcat        Opcode = 0xE6
+	OpModule        Opcode = 0xE7
+	OpImport        Opcode = 0xE8
 	OpCall          Opcode = 0xF0
 	OpReturnValue   Opcode = 0xF1
 	OpReturn        Opcode = 0xF2
+	OpClosure       Opcode = 0xF3
 )
 
 type Definition struct {
@@ -52,23 +91,56 @@ var definitions = map[Opcode]*Definition{
 	OpDiv:           {"OpDiv", []int{}},
 	OpMinus:         {"OpMinus", []int{}},
 	OpBang:          {"OpBang", []int{}},
-	OpTrue:          {"OpFalse", []int{}},
-	OpFalse:         {"OpTrue", []int{}},
+	OpMod:           {"OpMod", []int{}},
+	OpBitAnd:        {"OpBitAnd", []int{}},
+	OpBitOr:         {"OpBitOr", []int{}},
+	OpBitXor:        {"OpBitXor", []int{}},
+	OpShiftLeft:     {"OpShiftLeft", []int{}},
+	OpShiftRight:    {"OpShiftRight", []int{}},
+	OpBitNot:        {"OpBitNot", []int{}},
+	OpDup:           {"OpDup", []int{1}}, // number of values on top of the stack to push again
+	OpTrue:          {"OpTrue", []int{}},
+	OpFalse:         {"OpFalse", []int{}},
 	OpNull:          {"OpNull", []int{}},
 	OpEqual:         {"OpEqual", []int{}},
 	OpNotEqual:      {"OpNotEqual", []int{}},
 	OpGreaterThan:   {"OpGreaterThan", []int{}},
 	OpLessThan:      {"OpLessThan", []int{}},
+	OpGreaterEqual:  {"OpGreaterEqual", []int{}},
+	OpLessEqual:     {"OpLessEqual", []int{}},
 	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
 	OpJump:          {"OpJump", []int{2}},
 	OpGetGlobal:     {"OpGetGlobal", []int{2}},
 	OpSetGlobal:     {"OpSetGlobal", []int{2}},
+	OpGetLocal:      {"OpGetLocal", []int{1}},
+	OpSetLocal:      {"OpSetLocal", []int{1}},
+	OpGetFree:       {"OpGetFree", []int{1}},
+	OpGetBuiltin:    {"OpGetBuiltin", []int{1}},
+	OpSetFree:       {"OpSetFree", []int{1}},
+	OpCaptureLocal:  {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:   {"OpCaptureFree", []int{1}},
 	OpArray:         {"OpArray", []int{2}},
 	OpHash:          {"OpHash", []int{2}},
-	OpIndex:         {"OpIndex", []int{2}},
-	OpCall:          {"OpCall", []int{}},
+	OpIndex:         {"OpIndex", []int{}},
+	OpIter:          {"OpIter", []int{}},
+	OpSetIndex:      {"OpSetIndex", []int{}},
+	OpToString:      {"OpToString", []int{}},
+	OpConcat:        {"OpConcat", []int{2}},
+	OpModule:        {"OpModule", []int{2, 2}},
+	OpImport:        {"OpImport", []int{2, 2}}, // global index of the module, constant index of the module function
+	OpCall:          {"OpCall", []int{1}},
 	OpReturnValue:   {"OpReturnValue", []int{}},
 	OpReturn:        {"OpReturn", []int{}},
+	OpClosure:       {"OpClosure", []int{2, 1}}, // constant index, number of free variables
+}
+
+// Width returns the length of an instruction in bytes, including the opcode
+func (d *Definition) Width() int {
+	width := 1
+	for _, w := range d.OperandWidths {
+		width += w
+	}
+	return width
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -85,12 +157,7 @@ func Make(op Opcode, operands ...int) []byte {
 		return []byte{}
 	}
 
-	instrunctionLen := 1
-	for _, w := range definition.OperandWidths {
-		instrunctionLen += w
-	}
-
-	instruction := make([]byte, instrunctionLen)
+	instruction := make([]byte, definition.Width())
 	instruction[0] = byte(op)
 
 	offset := 1
@@ -99,6 +166,8 @@ func Make(op Opcode, operands ...int) []byte {
 		switch width {
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
 		}
 		offset += width
 	}
@@ -111,9 +180,14 @@ func (ins Instructions) String() string {
 	for i < len(ins) {
 		def, err := Lookup(ins[i])
 		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			fmt.Fprintf(&out, "%04d ERROR: %s\n", i, err)
+			i++
 			continue
 		}
+		if i+def.Width() > len(ins) {
+			fmt.Fprintf(&out, "%04d ERROR: truncated %s\n", i, def.Name)
+			break
+		}
 
 		operands, read := ReadOperands(def, ins[i+1:])
 		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
@@ -131,6 +205,8 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 		switch width {
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
 		}
 		offset += width
 	}
@@ -141,6 +217,10 @@ func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
 
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
+
 func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 	operandCount := len(def.OperandWidths)
 
@@ -153,6 +233,8 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 		return def.Name
 	case 1:
 		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
 	}
 	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
 }
@@ -10,6 +10,8 @@ func TestMake(t *testing.T) {
 	}{
 		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
 	}
 
 	for _, tt := range tests {
@@ -29,11 +31,20 @@ func TestMake(t *testing.T) {
 func TestInstructionsString(t *testing.T) {
 	instructions := []Instructions{
 		Make(OpAdd),
+		Make(OpGetLocal, 1),
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
+		Make(OpClosure, 65535, 255),
+		Make(OpTrue),
+		Make(OpFalse),
+		Make(OpIndex),
+		{0xFF},
+		Make(OpPop),
+		Make(OpConstant, 1)[:2],
 	}
 
-	expected := "0000 OpAdd\n0001 OpConstant 2\n0004 OpConstant 65535\n"
+	expected := "0000 OpAdd\n0001 OpGetLocal 1\n0003 OpConstant 2\n0006 OpConstant 65535\n0009 OpClosure 65535 255\n" +
+		"0013 OpTrue\n0014 OpFalse\n0015 OpIndex\n0016 ERROR: Opcode ff undefined\n0017 OpPop\n0018 ERROR: truncated OpConstant\n"
 
 	concatted := Instructions{}
 	for _, instruction := range instructions {
@@ -52,6 +63,8 @@ func TestReadOperands(t *testing.T) {
 		bytesRead int
 	}{
 		{OpConstant, []int{65535}, 2},
+		{OpGetLocal, []int{255}, 1},
+		{OpClosure, []int{65535, 255}, 3},
 	}
 
 	for _, tt := range tests {
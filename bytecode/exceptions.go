@@ -0,0 +1,100 @@
+package bytecode
+
+// ExceptionHandler catches the errors raised by the instructions in [Start, End) of a function.
+// Execution continues at Handler with the stack cut back to StackDepth values above the
+// function's locals and the error pushed on top.
+type ExceptionHandler struct {
+	Start      int
+	End        int
+	Handler    int
+	StackDepth int
+}
+
+// ExceptionTable lists the handlers of a function, inner handlers come before the ones enclosing them
+type ExceptionTable []ExceptionHandler
+
+// Lookup returns the innermost handler covering the instruction at ip
+func (t ExceptionTable) Lookup(ip int) (ExceptionHandler, bool) {
+	for _, h := range t {
+		if h.Start <= ip && ip < h.End {
+			return h, true
+		}
+	}
+	return ExceptionHandler{}, false
+}
+
+// ResolveStackDepths sets the StackDepth of every handler by following all paths through
+// the instructions of the function the table belongs to
+func (t ExceptionTable) ResolveStackDepths(ins Instructions) {
+	if len(ins) == 0 {
+		return
+	}
+	depths := map[int]int{0: 0}
+	pending := []int{0}
+	visit := func(offset int, depth int) {
+		if _, ok := depths[offset]; ok || offset >= len(ins) {
+			return
+		}
+		depths[offset] = depth
+		pending = append(pending, offset)
+	}
+
+	for len(pending) > 0 {
+		offset := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		depth := depths[offset]
+
+		for i := range t {
+			if t[i].Start == offset {
+				t[i].StackDepth = depth
+				visit(t[i].Handler, depth+1) // the handler starts with the error on the stack
+			}
+		}
+
+		def, err := Lookup(ins[offset])
+		if err != nil {
+			continue
+		}
+		operands, read := ReadOperands(def, ins[offset+1:])
+		op := Opcode(ins[offset])
+		next := depth + StackEffect(op, operands)
+
+		switch op {
+		case OpJump:
+			visit(operands[0], next)
+		case OpJumpNotTruthy:
+			visit(operands[0], next)
+			visit(offset+1+read, next)
+		case OpReturnValue, OpReturn:
+		default:
+			visit(offset+1+read, next)
+		}
+	}
+}
+
+// StackEffect returns the number of values an instruction pushes onto the stack
+// minus the number of values it pops off
+func StackEffect(op Opcode, operands []int) int {
+	switch op {
+	case OpConstant, OpTrue, OpFalse, OpNull, OpGetGlobal, OpGetLocal, OpGetFree, OpGetBuiltin,
+		OpCaptureLocal, OpCaptureFree, OpImport:
+		return 1
+	case OpPop, OpSetGlobal, OpSetLocal, OpSetFree, OpJumpNotTruthy, OpIndex, OpReturnValue,
+		OpAdd, OpSub, OpMul, OpDiv, OpMod, OpBitAnd, OpBitOr, OpBitXor, OpShiftLeft, OpShiftRight,
+		OpEqual, OpNotEqual, OpGreaterThan, OpLessThan, OpGreaterEqual, OpLessEqual:
+		return -1
+	case OpSetIndex:
+		return -2 // the collection, index and value are replaced by the value
+	case OpArray, OpHash, OpConcat:
+		return 1 - operands[0]
+	case OpDup:
+		return operands[0]
+	case OpCall:
+		return -operands[0] // the function and its arguments are replaced by the result
+	case OpClosure:
+		return 1 - operands[1]
+	case OpModule:
+		return 1 - 2*operands[1]
+	}
+	return 0
+}
@@ -1,20 +1,36 @@
 package compiler
 
 import (
+	"errors"
 	"fmt"
 	"monkey-int/ast"
 	"monkey-int/bytecode"
 	"monkey-int/object"
+	"monkey-int/token"
 	"sort"
+	"strings"
 )
 
 type Compiler struct {
+	// Sandbox restricts the files imported modules are read from, nil allows any file
+	Sandbox *object.Sandbox
+
 	constants []object.Object
 
+	pos token.Position // source position of the node currently being compiled
+
 	symbolTable *SymbolTable
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	// returns at the top level of a program or module jump to its end instead of returning
+	// from the main frame, the jumps are patched once the program is compiled
+	topLevelReturns []int
+
+	// err is the first operand that did not fit into its instruction, Compile reports it
+	// once the node being compiled is done
+	err error
 }
 
 type EmittedInstruction struct {
@@ -27,10 +43,16 @@ func New() *Compiler {
 		instructions:        bytecode.Instructions{},
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
+		sourceMap:           bytecode.SourceMap{},
+	}
+	symbolTable := NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
 	}
+
 	return &Compiler{
 		constants:   []object.Object{},
-		symbolTable: NewSymbolTable(),
+		symbolTable: symbolTable,
 		scopes:      []CompilationScope{mainScope},
 		scopeIndex:  0,
 	}
@@ -44,6 +66,15 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	if node == nil {
+		return nil
+	}
+	if pos := node.Pos(); pos.IsValid() {
+		previous := c.pos
+		c.pos = pos
+		defer func() { c.pos = previous }()
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
@@ -52,6 +83,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 		}
+
+		end := len(c.currentInstructions())
+		for _, pos := range c.topLevelReturns {
+			c.changeOperand(pos, end)
+		}
+		c.topLevelReturns = nil
 	case *ast.ExpressionStatement:
 		err := c.Compile(node.Expression)
 		if err != nil {
@@ -59,6 +96,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(bytecode.OpPop)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
+		}
 		err := c.Compile(node.Left)
 		if err != nil {
 			return err
@@ -68,25 +108,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		switch node.Operator {
-		case "+":
-			c.emit(bytecode.OpAdd)
-		case "-":
-			c.emit(bytecode.OpSub)
-		case "/":
-			c.emit(bytecode.OpDiv)
-		case "*":
-			c.emit(bytecode.OpMul)
-		case ">":
-			c.emit(bytecode.OpGreaterThan)
-		case "<":
-			c.emit(bytecode.OpLessThan)
-		case "==":
-			c.emit(bytecode.OpEqual)
-		case "!=":
-			c.emit(bytecode.OpNotEqual)
-		default:
-			return fmt.Errorf("Unknown operator: %s", node.Operator)
+		err = c.emitInfixOperator(node.Operator)
+		if err != nil {
+			return err
 		}
 	case *ast.Boolean:
 		if node.Value {
@@ -97,9 +121,23 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		c.emit(bytecode.OpConstant, c.addConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(bytecode.OpConstant, c.addConstant(float))
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
 		c.emit(bytecode.OpConstant, c.addConstant(str))
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			err := c.Compile(part)
+			if err != nil {
+				return err
+			}
+			if _, ok := part.(*ast.StringLiteral); !ok {
+				c.emit(bytecode.OpToString)
+			}
+		}
+		c.emit(bytecode.OpConcat, len(node.Parts))
 	case *ast.PrefixExpression:
 		err := c.Compile(node.Right)
 		if err != nil {
@@ -111,8 +149,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(bytecode.OpBang)
 		case "-":
 			c.emit(bytecode.OpMinus)
+		case "~":
+			c.emit(bytecode.OpBitNot)
 		default:
-			return fmt.Errorf("Unknown operator: %s", node.Operator)
+			return c.errorf("Unknown operator: %s", node.Operator)
 		}
 	case *ast.IfExpression:
 		err := c.Compile(node.Condition)
@@ -121,19 +161,18 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 		jnTruthyPos := c.emit(bytecode.OpJumpNotTruthy, 9999)
+		depth := c.scopes[c.scopeIndex].stackDepth
 
 		err = c.Compile(node.Consequence)
 		if err != nil {
 			return err
 		}
-
-		if c.lastInstructionIs(bytecode.OpPop) {
-			c.removeLastPop()
-		}
+		c.keepBlockValue()
 
 		jumpPos := c.emit(bytecode.OpJump, 9999)
 		afterConsequencePos := len(c.currentInstructions())
 		c.changeOperand(jnTruthyPos, afterConsequencePos)
+		c.scopes[c.scopeIndex].stackDepth = depth
 
 		if node.Alternative == nil {
 			c.emit(bytecode.OpNull)
@@ -142,14 +181,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if err != nil {
 				return err
 			}
-
-			if c.lastInstructionIs(bytecode.OpPop) {
-				c.removeLastPop()
-			}
+			c.keepBlockValue()
 		}
 
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
+	case *ast.TryExpression:
+		return c.compileTryExpression(node)
 	case *ast.BlockStatement:
 		for _, s := range node.Statements {
 			err := c.Compile(s)
@@ -158,18 +196,92 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 	case *ast.LetStatement:
+		// the value is compiled first, so it still sees a previous binding of the same name,
+		// except for function literals, which refer to the variable they are bound to like
+		// any variable of an enclosing scope, so that assignments to it are seen by them
+		if fn, ok := node.Value.(*ast.FunctionLiteral); ok && fn.Name != "" {
+			c.symbolTable.Define(node.Name.Value)
+		}
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
 		symbol := c.symbolTable.Define(node.Name.Value)
-		c.emit(bytecode.OpSetGlobal, symbol.Index)
+		c.storeSymbol(symbol)
+	case *ast.ImportStatement:
+		return c.compileImportStatement(node)
+	case *ast.WhileStatement:
+		loopStart := len(c.currentInstructions())
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+		exitJumpPos := c.emit(bytecode.OpJumpNotTruthy, 9999)
+
+		err = c.compileLoopBody(node.Body, loopStart, exitJumpPos)
+		if err != nil {
+			return err
+		}
+	case *ast.ForStatement:
+		// the loop is compiled like
+		//   let $items = <iterable>; let $index = 0;
+		//   while ($index < len($items)) { let x = $items[$index]; $index = $index + 1; <body> }
+		err := c.Compile(node.Iterable)
+		if err != nil {
+			return err
+		}
+		c.emit(bytecode.OpIter)
+		// hidden names are unique per nesting level so nested loops don't share slots
+		depth := len(c.scopes[c.scopeIndex].loops)
+		items := c.symbolTable.Define(fmt.Sprintf("$items%d", depth))
+		c.storeSymbol(items)
+
+		index := c.symbolTable.Define(fmt.Sprintf("$index%d", depth))
+		c.emit(bytecode.OpConstant, c.addConstant(&object.Integer{Value: 0}))
+		c.storeSymbol(index)
+
+		loopStart := len(c.currentInstructions())
+		c.loadSymbol(index)
+		c.emit(bytecode.OpGetBuiltin, builtinIndex("len"))
+		c.loadSymbol(items)
+		c.emit(bytecode.OpCall, 1)
+		c.emit(bytecode.OpLessThan)
+		exitJumpPos := c.emit(bytecode.OpJumpNotTruthy, 9999)
+
+		c.loadSymbol(items)
+		c.loadSymbol(index)
+		c.emit(bytecode.OpIndex)
+		variable := c.symbolTable.Define(node.Variable.Value)
+		c.storeSymbol(variable)
+
+		c.loadSymbol(index)
+		c.emit(bytecode.OpConstant, c.addConstant(&object.Integer{Value: 1}))
+		c.emit(bytecode.OpAdd)
+		c.storeSymbol(index)
+
+		err = c.compileLoopBody(node.Body, loopStart, exitJumpPos)
+		if err != nil {
+			return err
+		}
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return c.errorf("break outside of loop")
+		}
+		jumpPos := c.emitLoopJump(loop, 9999)
+		loop.breakJumps = append(loop.breakJumps, jumpPos)
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return c.errorf("continue outside of loop")
+		}
+		c.emitLoopJump(loop, loop.start)
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("Unknown symbol: %s", node.Value)
+			return c.errorf("Unknown symbol: %s", node.Value)
 		}
-		c.emit(bytecode.OpGetGlobal, symbol.Index)
+		c.loadSymbol(symbol)
 	case *ast.ArrayLiteral:
 		for _, el := range node.Elements {
 			err := c.Compile(el)
@@ -211,8 +323,15 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 		c.emit(bytecode.OpIndex)
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(node)
 	case *ast.FunctionLiteral:
 		c.enterScope()
+
+		for _, p := range node.Parameters {
+			c.symbolTable.Define(p.Value)
+		}
+
 		err := c.Compile(node.Body)
 		if err != nil {
 			return err
@@ -226,36 +345,89 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(bytecode.OpReturn)
 		}
 
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.numDefinitions
+		localNames := c.symbolTable.localNames
+		sourceMap := c.scopes[c.scopeIndex].sourceMap
+		handlers := c.currentHandlers()
 		instructions := c.leaveScope()
 
-		compiledFn := &object.CompiledFunction{Instructions: instructions}
-		c.emit(bytecode.OpConstant, c.addConstant(compiledFn))
+		freeNames := make([]string, len(freeSymbols))
+		for i, s := range freeSymbols {
+			c.captureSymbol(s)
+			freeNames[i] = s.Name
+		}
+
+		compiledFn := &object.CompiledFunction{
+			Name:          node.Name,
+			Instructions:  instructions,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			SourceMap:     sourceMap,
+			Handlers:      handlers,
+			LocalNames:    localNames,
+			FreeNames:     freeNames,
+		}
+		fnIndex := c.addConstant(compiledFn)
+		c.emit(bytecode.OpClosure, fnIndex, len(freeSymbols))
 	case *ast.ReturnStatement:
 		err := c.Compile(node.ReturnValue)
 		if err != nil {
 			return err
 		}
+		if c.scopeIndex == 0 {
+			// the value of the program is the last value popped
+			c.emit(bytecode.OpPop)
+			c.topLevelReturns = append(c.topLevelReturns, c.emit(bytecode.OpJump, 9999))
+			return nil
+		}
 		c.emit(bytecode.OpReturnValue)
 	case *ast.CallExpression:
 		err := c.Compile(node.Function)
 		if err != nil {
 			return err
 		}
-		c.emit(bytecode.OpCall)
+
+		for _, arg := range node.Arguments {
+			err := c.Compile(arg)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(bytecode.OpCall, len(node.Arguments))
 	}
-	return nil
+	return c.err
 }
 
 func (c *Compiler) Bytecode() *MyBytecode {
 	return &MyBytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		SourceMap:    c.scopes[c.scopeIndex].sourceMap,
+		Handlers:     c.currentHandlers(),
+		GlobalNames:  c.symbolTable.GlobalNames(),
 	}
 }
 
 type MyBytecode struct {
 	Instructions bytecode.Instructions
 	Constants    []object.Object
+	SourceMap    bytecode.SourceMap
+	Handlers     bytecode.ExceptionTable
+
+	// GlobalNames are the names of the globals by index, reported when a global is read
+	// before its let has run
+	GlobalNames []string
+}
+
+// errorf creates a compilation error prefixed with the position of the node being compiled
+func (c *Compiler) errorf(format string, a ...interface{}) error {
+	msg := fmt.Sprintf(format, a...)
+	if c.pos.IsValid() {
+		return fmt.Errorf("%s: %s", c.pos, msg)
+	}
+	return errors.New(msg)
 }
 
 func (c *Compiler) addConstant(obj object.Object) int {
@@ -264,12 +436,75 @@ func (c *Compiler) addConstant(obj object.Object) int {
 }
 
 func (c *Compiler) emit(op bytecode.Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
 	ins := bytecode.Make(op, operands...)
 	pos := c.addInstruction(ins)
 	c.setLastInstruction(op, pos)
+	c.scopes[c.scopeIndex].stackDepth += bytecode.StackEffect(op, operands)
+	if c.pos.IsValid() {
+		c.scopes[c.scopeIndex].sourceMap[pos] = c.pos
+	}
 	return pos
 }
 
+// checkOperands records an error if an operand does not fit into the width the instruction
+// encodes it with, instead of letting Make truncate it
+func (c *Compiler) checkOperands(op bytecode.Opcode, operands []int) {
+	definition, err := bytecode.Lookup(byte(op))
+	if err != nil || c.err != nil {
+		return
+	}
+	for i, operand := range operands {
+		max := 1<<(8*definition.OperandWidths[i]) - 1
+		if operand <= max {
+			continue
+		}
+		switch op {
+		case bytecode.OpConstant:
+			c.err = c.errorf("too many constants (max %d)", max+1)
+		case bytecode.OpGetGlobal, bytecode.OpSetGlobal:
+			c.err = c.errorf("too many global variables (max %d)", max+1)
+		case bytecode.OpGetLocal, bytecode.OpSetLocal, bytecode.OpCaptureLocal:
+			c.err = c.errorf("too many local variables (max %d)", max+1)
+		case bytecode.OpGetFree, bytecode.OpSetFree, bytecode.OpCaptureFree:
+			c.err = c.errorf("too many free variables (max %d)", max+1)
+		case bytecode.OpGetBuiltin:
+			c.err = c.errorf("too many builtins (max %d)", max+1)
+		case bytecode.OpCall:
+			c.err = c.errorf("too many arguments (max %d)", max)
+		case bytecode.OpArray:
+			c.err = c.errorf("too many array elements (max %d)", max)
+		case bytecode.OpHash:
+			c.err = c.errorf("too many hash pairs (max %d)", max/2)
+		case bytecode.OpConcat:
+			c.err = c.errorf("too many parts in string (max %d)", max)
+		case bytecode.OpJump, bytecode.OpJumpNotTruthy:
+			c.err = c.errorf("function too large, jump target exceeds %d", max)
+		case bytecode.OpClosure:
+			if i == 0 {
+				c.err = c.errorf("too many constants (max %d)", max+1)
+			} else {
+				c.err = c.errorf("too many free variables (max %d)", max)
+			}
+		case bytecode.OpImport:
+			if i == 0 {
+				c.err = c.errorf("too many global variables (max %d)", max+1)
+			} else {
+				c.err = c.errorf("too many constants (max %d)", max+1)
+			}
+		case bytecode.OpModule:
+			if i == 0 {
+				c.err = c.errorf("too many constants (max %d)", max+1)
+			} else {
+				c.err = c.errorf("too many exports (max %d)", max)
+			}
+		default:
+			c.err = c.errorf("operand %d of %s exceeds %d", operand, definition.Name, max)
+		}
+		return
+	}
+}
+
 func (c *Compiler) addInstruction(ins []byte) int {
 	posNewInstruction := len(c.currentInstructions())
 	updatedInstructions := append(c.currentInstructions(), ins...)
@@ -300,6 +535,7 @@ func (c *Compiler) removeLastPop() {
 
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+	c.scopes[c.scopeIndex].stackDepth++
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
@@ -311,6 +547,7 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 
 func (c *Compiler) changeOperand(opPos int, operand int) {
 	op := bytecode.Opcode(c.currentInstructions()[opPos])
+	c.checkOperands(op, []int{operand})
 	newInstruction := bytecode.Make(op, operand)
 	c.replaceInstruction(opPos, newInstruction)
 }
@@ -319,29 +556,342 @@ type CompilationScope struct {
 	instructions        bytecode.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	sourceMap           bytecode.SourceMap
+	loops               []*loopScope // loops enclosing the current instruction, innermost last
+	handlers            bytecode.ExceptionTable
+	catches             int // number of catch handlers enclosing the current instruction
+
+	// stackDepth is the number of values on the stack of the function after the instructions
+	// emitted so far, the code following a jump continues with the depth of the jump target
+	stackDepth int
+}
+
+// loopScope tracks the jump targets of a loop being compiled
+type loopScope struct {
+	start      int   // position continue jumps to
+	breakJumps []int // positions of OpJump instructions to patch with the loop exit
+	stackDepth int   // stack depth of the loop body, break and continue pop down to it
+}
+
+// compileLoopBody compiles a loop body that jumps back to loopStart and patches the
+// exit jump at exitJumpPos as well as all breaks in the body with the loop exit
+func (c *Compiler) compileLoopBody(body *ast.BlockStatement, loopStart int, exitJumpPos int) error {
+	scope := &c.scopes[c.scopeIndex]
+	loop := &loopScope{start: loopStart, stackDepth: scope.stackDepth}
+	scope.loops = append(scope.loops, loop)
+
+	err := c.Compile(body)
+	if err != nil {
+		return err
+	}
+	c.emit(bytecode.OpJump, loopStart)
+
+	scope = &c.scopes[c.scopeIndex]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	loopExit := len(c.currentInstructions())
+	c.changeOperand(exitJumpPos, loopExit)
+	for _, pos := range loop.breakJumps {
+		c.changeOperand(pos, loopExit)
+	}
+	return nil
+}
+
+// compileTryExpression compiles
+//
+//	start:   <block>
+//	end:     OpJump after
+//	handler: <store the error in the catch parameter>
+//	         <handler block>
+//	after:
+//
+// and adds an exception table entry sending errors raised between start and end to handler
+func (c *Compiler) compileTryExpression(node *ast.TryExpression) error {
+	start := len(c.currentInstructions())
+	err := c.Compile(node.Block)
+	if err != nil {
+		return err
+	}
+	if len(c.currentInstructions()) == start {
+		c.emit(bytecode.OpNull) // an empty block must not pick up the value of the preceding statement
+	} else {
+		c.keepBlockValue()
+	}
+	end := len(c.currentInstructions())
+	jumpPos := c.emit(bytecode.OpJump, 9999)
+
+	handler := len(c.currentInstructions())
+	scope := &c.scopes[c.scopeIndex]
+	scope.handlers = append(scope.handlers, bytecode.ExceptionHandler{Start: start, End: end, Handler: handler})
+
+	// the catch parameter is bound to a slot of its own while compiling the handler, so that
+	// it does not overwrite a variable of the same name; the slots are unique per nesting level
+	symbol := c.symbolTable.Define(fmt.Sprintf("$catch%d", scope.catches))
+	c.storeSymbol(symbol)
+	restore := c.symbolTable.shadow(node.Parameter.Value, symbol)
+	scope.catches++
+	err = c.Compile(node.Handler)
+	scope = &c.scopes[c.scopeIndex]
+	scope.catches--
+	restore()
+	if err != nil {
+		return err
+	}
+	c.keepBlockValue()
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// emitLoopJump pops the values the expressions enclosing a break or continue have pushed
+// since the loop body started and jumps to target, it returns the position of the jump
+func (c *Compiler) emitLoopJump(loop *loopScope, target int) int {
+	depth := c.scopes[c.scopeIndex].stackDepth
+	for i := loop.stackDepth; i < depth; i++ {
+		c.emit(bytecode.OpPop)
+	}
+	jumpPos := c.emit(bytecode.OpJump, target)
+	// the code following the jump is only reached by the enclosing expression
+	c.scopes[c.scopeIndex].stackDepth = depth
+	return jumpPos
+}
+
+func (c *Compiler) currentLoop() *loopScope {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+// keepBlockValue makes a compiled block leave its value on the stack: the value of the
+// last expression statement, or null if the block ends with any other statement
+func (c *Compiler) keepBlockValue() {
+	if c.lastInstructionIs(bytecode.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(bytecode.OpNull)
+	}
+}
+
+func builtinIndex(name string) int {
+	for i, def := range object.Builtins {
+		if def.Name == name {
+			return i
+		}
+	}
+	return -1
 }
 
 func (c *Compiler) currentInstructions() bytecode.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
 
+// currentHandlers returns the exception table of the current scope with its stack depths resolved
+func (c *Compiler) currentHandlers() bytecode.ExceptionTable {
+	handlers := c.scopes[c.scopeIndex].handlers
+	handlers.ResolveStackDepths(c.currentInstructions())
+	return handlers
+}
+
 func (c *Compiler) enterScope() {
 	scope := CompilationScope{
 		instructions:        bytecode.Instructions{},
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
+		sourceMap:           bytecode.SourceMap{},
 	}
 	c.scopes = append(c.scopes, scope)
 	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
 }
 
 func (c *Compiler) leaveScope() bytecode.Instructions {
 	instructions := c.currentInstructions()
 	c.scopes = c.scopes[:len(c.scopes)-1]
 	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
 	return instructions
 }
 
+func (c *Compiler) emitInfixOperator(operator string) error {
+	switch operator {
+	case "+":
+		c.emit(bytecode.OpAdd)
+	case "-":
+		c.emit(bytecode.OpSub)
+	case "/":
+		c.emit(bytecode.OpDiv)
+	case "*":
+		c.emit(bytecode.OpMul)
+	case "%":
+		c.emit(bytecode.OpMod)
+	case "&":
+		c.emit(bytecode.OpBitAnd)
+	case "|":
+		c.emit(bytecode.OpBitOr)
+	case "^":
+		c.emit(bytecode.OpBitXor)
+	case "<<":
+		c.emit(bytecode.OpShiftLeft)
+	case ">>":
+		c.emit(bytecode.OpShiftRight)
+	case ">":
+		c.emit(bytecode.OpGreaterThan)
+	case "<":
+		c.emit(bytecode.OpLessThan)
+	case ">=":
+		c.emit(bytecode.OpGreaterEqual)
+	case "<=":
+		c.emit(bytecode.OpLessEqual)
+	case "==":
+		c.emit(bytecode.OpEqual)
+	case "!=":
+		c.emit(bytecode.OpNotEqual)
+	default:
+		return c.errorf("Unknown operator: %s", operator)
+	}
+	return nil
+}
+
+// compileLogicalExpression short-circuits && and || with jumps, the result is always a boolean:
+//
+//	a && b: <a> OpJumpNotTruthy FALSE <b> OpBang OpBang OpJump END; FALSE: OpFalse; END:
+//	a || b: <a> OpJumpNotTruthy RIGHT OpTrue OpJump END; RIGHT: <b> OpBang OpBang; END:
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+	jumpNotTruthyPos := c.emit(bytecode.OpJumpNotTruthy, 9999)
+	depth := c.scopes[c.scopeIndex].stackDepth
+
+	if node.Operator == "||" {
+		c.emit(bytecode.OpTrue)
+		jumpPos := c.emit(bytecode.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		c.scopes[c.scopeIndex].stackDepth = depth
+
+		err = c.Compile(node.Right)
+		if err != nil {
+			return err
+		}
+		c.emit(bytecode.OpBang)
+		c.emit(bytecode.OpBang)
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+		return nil
+	}
+
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+	c.emit(bytecode.OpBang)
+	c.emit(bytecode.OpBang)
+	jumpPos := c.emit(bytecode.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	c.scopes[c.scopeIndex].stackDepth = depth
+	c.emit(bytecode.OpFalse)
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileAssignExpression leaves the assigned value on the stack, as assignments are expressions
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	compound := node.Operator != "="
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			return c.errorf("assignment to undeclared variable: %s", target.Value)
+		}
+		if symbol.Scope == BuiltinScope {
+			return c.errorf("cannot assign to %s", target.Value)
+		}
+		if compound {
+			c.loadSymbol(symbol)
+		}
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		if compound {
+			err = c.emitInfixOperator(strings.TrimSuffix(node.Operator, "="))
+			if err != nil {
+				return err
+			}
+		}
+		c.storeSymbol(symbol)
+		c.loadSymbol(symbol)
+	case *ast.IndexExpression:
+		err := c.Compile(target.Left)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(target.Index)
+		if err != nil {
+			return err
+		}
+		if compound {
+			// the container and index are kept for OpSetIndex while reading the current value
+			c.emit(bytecode.OpDup, 2)
+			c.emit(bytecode.OpIndex)
+		}
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		if compound {
+			err = c.emitInfixOperator(strings.TrimSuffix(node.Operator, "="))
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(bytecode.OpSetIndex)
+	default:
+		return c.errorf("cannot assign to %s", node.Target.String())
+	}
+	return nil
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(bytecode.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(bytecode.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(bytecode.OpSetFree, s.Index)
+	}
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(bytecode.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(bytecode.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(bytecode.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(bytecode.OpGetFree, s.Index)
+	}
+}
+
+// captureSymbol pushes the cell of a variable captured by a closure, closures share the
+// cells with the scope defining the variable so that assignments are seen by both
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(bytecode.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(bytecode.OpCaptureFree, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
 func (c *Compiler) replaceLastPopWithReturn() {
 	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
 	c.replaceInstruction(lastPos, bytecode.Make(bytecode.OpReturnValue))
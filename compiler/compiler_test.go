@@ -7,6 +7,9 @@ import (
 	"monkey-int/lexer"
 	"monkey-int/object"
 	"monkey-int/parser"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -145,6 +148,11 @@ func testConstants(t *testing.T, expected []interface{}, actual []object.Object)
 			if err != nil {
 				return fmt.Errorf("Constant %d - testIntegerObject failed: %s", i, err)
 			}
+		case float64:
+			err := testFloatObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("Constant %d - testFloatObject failed: %s", i, err)
+			}
 		case string:
 			err := testStringObject(constant, actual[i])
 			if err != nil {
@@ -177,6 +185,19 @@ func testStringObject(expected string, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not a Float. Got=%T (%+v) instead.", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("Object has wrong value. Wanted=%g, got=%g instead.", expected, result.Value)
+	}
+
+	return nil
+}
+
 func testIntegerObject(expected int64, actual object.Object) error {
 	result, ok := actual.(*object.Integer)
 	if !ok {
@@ -542,7 +563,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []bytecode.Instructions{
-				bytecode.Make(bytecode.OpConstant, 2),
+				bytecode.Make(bytecode.OpClosure, 2, 0),
 				bytecode.Make(bytecode.OpPop),
 			},
 		},
@@ -558,7 +579,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []bytecode.Instructions{
-				bytecode.Make(bytecode.OpConstant, 2),
+				bytecode.Make(bytecode.OpClosure, 2, 0),
 				bytecode.Make(bytecode.OpPop),
 			},
 		},
@@ -574,7 +595,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []bytecode.Instructions{
-				bytecode.Make(bytecode.OpConstant, 2),
+				bytecode.Make(bytecode.OpClosure, 2, 0),
 				bytecode.Make(bytecode.OpPop),
 			},
 		},
@@ -587,6 +608,8 @@ func TestCompilerScopes(t *testing.T) {
 	if compiler.scopeIndex != 0 {
 		t.Errorf("scopeIndex wrong. Got=%d, wanted=%d", compiler.scopeIndex, 0)
 	}
+	globalSymbolTable := compiler.symbolTable
+
 	compiler.emit(bytecode.OpMul)
 	compiler.enterScope()
 	if compiler.scopeIndex != 1 {
@@ -600,10 +623,19 @@ func TestCompilerScopes(t *testing.T) {
 	if last.Opcode != bytecode.OpSub {
 		t.Errorf("lastInstruction.Opcode wrong. Got=%d, wanted=%d", last.Opcode, bytecode.OpSub)
 	}
+	if compiler.symbolTable.Outer != globalSymbolTable {
+		t.Errorf("Compiler did not enclose symbolTable")
+	}
 	compiler.leaveScope()
 	if compiler.scopeIndex != 0 {
 		t.Errorf("scopeIndex wrong. Got=%d, wanted=%d", compiler.scopeIndex, 0)
 	}
+	if compiler.symbolTable != globalSymbolTable {
+		t.Errorf("Compiler did not restore global symbol table")
+	}
+	if compiler.symbolTable.Outer != nil {
+		t.Errorf("Compiler modified global symbol table incorrectly")
+	}
 	compiler.emit(bytecode.OpAdd)
 	if len(compiler.scopes[compiler.scopeIndex].instructions) != 2 {
 		t.Errorf("Instructions length wrong. Got=%d", len(compiler.scopes[compiler.scopeIndex].instructions))
@@ -628,7 +660,7 @@ func TestFunctionsWithoutReturnValue(t *testing.T) {
 				},
 			},
 			expectedInstructions: []bytecode.Instructions{
-				bytecode.Make(bytecode.OpConstant, 0),
+				bytecode.Make(bytecode.OpClosure, 0, 0),
 				bytecode.Make(bytecode.OpPop),
 			},
 		},
@@ -648,8 +680,8 @@ func TestFunctionCalls(t *testing.T) {
 				},
 			},
 			expectedInstructions: []bytecode.Instructions{
-				bytecode.Make(bytecode.OpConstant, 1), // The compiled function
-				bytecode.Make(bytecode.OpCall),
+				bytecode.Make(bytecode.OpClosure, 1, 0), // The compiled function
+				bytecode.Make(bytecode.OpCall, 0),
 				bytecode.Make(bytecode.OpPop),
 			},
 		},
@@ -666,13 +698,654 @@ func TestFunctionCalls(t *testing.T) {
 				},
 			},
 			expectedInstructions: []bytecode.Instructions{
-				bytecode.Make(bytecode.OpConstant, 1), // The compiled function
+				bytecode.Make(bytecode.OpClosure, 1, 0), // The compiled function
+				bytecode.Make(bytecode.OpSetGlobal, 0),
+				bytecode.Make(bytecode.OpGetGlobal, 0),
+				bytecode.Make(bytecode.OpCall, 0),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestFunctionCallsWithArguments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+	let oneArg = fn(a) { a };
+	oneArg(24);
+	`,
+			expectedConstants: []interface{}{
+				[]bytecode.Instructions{
+					bytecode.Make(bytecode.OpGetLocal, 0),
+					bytecode.Make(bytecode.OpReturnValue),
+				},
+				24,
+			},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpClosure, 0, 0),
+				bytecode.Make(bytecode.OpSetGlobal, 0),
+				bytecode.Make(bytecode.OpGetGlobal, 0),
+				bytecode.Make(bytecode.OpConstant, 1),
+				bytecode.Make(bytecode.OpCall, 1),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+		{
+			input: `
+	let manyArg = fn(a, b, c) { a; b; c };
+	manyArg(24, 25, 26);
+	`,
+			expectedConstants: []interface{}{
+				[]bytecode.Instructions{
+					bytecode.Make(bytecode.OpGetLocal, 0),
+					bytecode.Make(bytecode.OpPop),
+					bytecode.Make(bytecode.OpGetLocal, 1),
+					bytecode.Make(bytecode.OpPop),
+					bytecode.Make(bytecode.OpGetLocal, 2),
+					bytecode.Make(bytecode.OpReturnValue),
+				},
+				24,
+				25,
+				26,
+			},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpClosure, 0, 0),
 				bytecode.Make(bytecode.OpSetGlobal, 0),
 				bytecode.Make(bytecode.OpGetGlobal, 0),
-				bytecode.Make(bytecode.OpCall),
+				bytecode.Make(bytecode.OpConstant, 1),
+				bytecode.Make(bytecode.OpConstant, 2),
+				bytecode.Make(bytecode.OpConstant, 3),
+				bytecode.Make(bytecode.OpCall, 3),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestLetStatementScopes(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+		let num = 55;
+		fn() { num }
+		`,
+			expectedConstants: []interface{}{
+				55,
+				[]bytecode.Instructions{
+					bytecode.Make(bytecode.OpGetGlobal, 0),
+					bytecode.Make(bytecode.OpReturnValue),
+				},
+			},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpConstant, 0),
+				bytecode.Make(bytecode.OpSetGlobal, 0),
+				bytecode.Make(bytecode.OpClosure, 1, 0),
 				bytecode.Make(bytecode.OpPop),
 			},
 		},
+		{
+			input: `
+		fn() {
+			let a = 55;
+			let b = 77;
+			a + b
+		}
+		`,
+			expectedConstants: []interface{}{
+				55,
+				77,
+				[]bytecode.Instructions{
+					bytecode.Make(bytecode.OpConstant, 0),
+					bytecode.Make(bytecode.OpSetLocal, 0),
+					bytecode.Make(bytecode.OpConstant, 1),
+					bytecode.Make(bytecode.OpSetLocal, 1),
+					bytecode.Make(bytecode.OpGetLocal, 0),
+					bytecode.Make(bytecode.OpGetLocal, 1),
+					bytecode.Make(bytecode.OpAdd),
+					bytecode.Make(bytecode.OpReturnValue),
+				},
+			},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpClosure, 2, 0),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			fn(a) {
+				fn(b) {
+					a + b
+				}
+			}
+			`,
+			expectedConstants: []interface{}{
+				[]bytecode.Instructions{
+					bytecode.Make(bytecode.OpGetFree, 0),
+					bytecode.Make(bytecode.OpGetLocal, 0),
+					bytecode.Make(bytecode.OpAdd),
+					bytecode.Make(bytecode.OpReturnValue),
+				},
+				[]bytecode.Instructions{
+					bytecode.Make(bytecode.OpCaptureLocal, 0),
+					bytecode.Make(bytecode.OpClosure, 0, 1),
+					bytecode.Make(bytecode.OpReturnValue),
+				},
+			},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpClosure, 1, 0),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+		{
+			input: `
+			fn(a) {
+				fn(b) {
+					fn(c) {
+						a + b + c
+					}
+				}
+			};
+			`,
+			expectedConstants: []interface{}{
+				[]bytecode.Instructions{
+					bytecode.Make(bytecode.OpGetFree, 0),
+					bytecode.Make(bytecode.OpGetFree, 1),
+					bytecode.Make(bytecode.OpAdd),
+					bytecode.Make(bytecode.OpGetLocal, 0),
+					bytecode.Make(bytecode.OpAdd),
+					bytecode.Make(bytecode.OpReturnValue),
+				},
+				[]bytecode.Instructions{
+					bytecode.Make(bytecode.OpCaptureFree, 0),
+					bytecode.Make(bytecode.OpCaptureLocal, 0),
+					bytecode.Make(bytecode.OpClosure, 0, 2),
+					bytecode.Make(bytecode.OpReturnValue),
+				},
+				[]bytecode.Instructions{
+					bytecode.Make(bytecode.OpCaptureLocal, 0),
+					bytecode.Make(bytecode.OpClosure, 1, 1),
+					bytecode.Make(bytecode.OpReturnValue),
+				},
+			},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpClosure, 2, 0),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let countDown = fn(x) { countDown(x - 1); };
+			countDown(1);
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]bytecode.Instructions{
+					bytecode.Make(bytecode.OpGetGlobal, 0),
+					bytecode.Make(bytecode.OpGetLocal, 0),
+					bytecode.Make(bytecode.OpConstant, 0),
+					bytecode.Make(bytecode.OpSub),
+					bytecode.Make(bytecode.OpCall, 1),
+					bytecode.Make(bytecode.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpClosure, 1, 0),
+				bytecode.Make(bytecode.OpSetGlobal, 0),
+				bytecode.Make(bytecode.OpGetGlobal, 0),
+				bytecode.Make(bytecode.OpConstant, 2),
+				bytecode.Make(bytecode.OpCall, 1),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			len([]);
+			push([], 1);
+			`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpGetBuiltin, 0),
+				bytecode.Make(bytecode.OpArray, 0),
+				bytecode.Make(bytecode.OpCall, 1),
+				bytecode.Make(bytecode.OpPop),
+				bytecode.Make(bytecode.OpGetBuiltin, 5),
+				bytecode.Make(bytecode.OpArray, 0),
+				bytecode.Make(bytecode.OpConstant, 0),
+				bytecode.Make(bytecode.OpCall, 2),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+		{
+			input: `fn() { len([]) }`,
+			expectedConstants: []interface{}{
+				[]bytecode.Instructions{
+					bytecode.Make(bytecode.OpGetBuiltin, 0),
+					bytecode.Make(bytecode.OpArray, 0),
+					bytecode.Make(bytecode.OpCall, 1),
+					bytecode.Make(bytecode.OpReturnValue),
+				},
+			},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpClosure, 0, 0),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1.5 + 2",
+			expectedConstants: []interface{}{1.5, 2},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpConstant, 0),
+				bytecode.Make(bytecode.OpConstant, 1),
+				bytecode.Make(bytecode.OpAdd),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+		{
+			input:             "-0.5",
+			expectedConstants: []interface{}{0.5},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpConstant, 0),
+				bytecode.Make(bytecode.OpMinus),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestWhileLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `while (true) { 1; break; }; 2;`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []bytecode.Instructions{
+				// 0000
+				bytecode.Make(bytecode.OpTrue),
+				// 0001
+				bytecode.Make(bytecode.OpJumpNotTruthy, 14),
+				// 0004
+				bytecode.Make(bytecode.OpConstant, 0),
+				// 0007
+				bytecode.Make(bytecode.OpPop),
+				// 0008
+				bytecode.Make(bytecode.OpJump, 14),
+				// 0011
+				bytecode.Make(bytecode.OpJump, 0),
+				// 0014
+				bytecode.Make(bytecode.OpConstant, 1),
+				// 0017
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+		{
+			input:             `while (false) { continue; }`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []bytecode.Instructions{
+				// 0000
+				bytecode.Make(bytecode.OpFalse),
+				// 0001
+				bytecode.Make(bytecode.OpJumpNotTruthy, 10),
+				// 0004
+				bytecode.Make(bytecode.OpJump, 0),
+				// 0007
+				bytecode.Make(bytecode.OpJump, 0),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `let a = 1; a += 2;`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpConstant, 0),
+				bytecode.Make(bytecode.OpSetGlobal, 0),
+				bytecode.Make(bytecode.OpGetGlobal, 0),
+				bytecode.Make(bytecode.OpConstant, 1),
+				bytecode.Make(bytecode.OpAdd),
+				bytecode.Make(bytecode.OpSetGlobal, 0),
+				bytecode.Make(bytecode.OpGetGlobal, 0),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+		{
+			input:             `let a = 1; let a = 2;`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpConstant, 0),
+				bytecode.Make(bytecode.OpSetGlobal, 0),
+				bytecode.Make(bytecode.OpConstant, 1),
+				bytecode.Make(bytecode.OpSetGlobal, 0),
+			},
+		},
+		{
+			input:             `let a = [1]; a[0] += 2;`,
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpConstant, 0),
+				bytecode.Make(bytecode.OpArray, 1),
+				bytecode.Make(bytecode.OpSetGlobal, 0),
+				bytecode.Make(bytecode.OpGetGlobal, 0),
+				bytecode.Make(bytecode.OpConstant, 1),
+				bytecode.Make(bytecode.OpDup, 2),
+				bytecode.Make(bytecode.OpIndex),
+				bytecode.Make(bytecode.OpConstant, 2),
+				bytecode.Make(bytecode.OpAdd),
+				bytecode.Make(bytecode.OpSetIndex),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+		{
+			input:             `let a = [1]; a[0] = 2;`,
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpConstant, 0),
+				bytecode.Make(bytecode.OpArray, 1),
+				bytecode.Make(bytecode.OpSetGlobal, 0),
+				bytecode.Make(bytecode.OpGetGlobal, 0),
+				bytecode.Make(bytecode.OpConstant, 1),
+				bytecode.Make(bytecode.OpConstant, 2),
+				bytecode.Make(bytecode.OpSetIndex),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+		{
+			input: `fn() { let a = 1; fn() { a = 2 } }`,
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]bytecode.Instructions{
+					bytecode.Make(bytecode.OpConstant, 1),
+					bytecode.Make(bytecode.OpSetFree, 0),
+					bytecode.Make(bytecode.OpGetFree, 0),
+					bytecode.Make(bytecode.OpReturnValue),
+				},
+				[]bytecode.Instructions{
+					bytecode.Make(bytecode.OpConstant, 0),
+					bytecode.Make(bytecode.OpSetLocal, 0),
+					bytecode.Make(bytecode.OpCaptureLocal, 0),
+					bytecode.Make(bytecode.OpClosure, 2, 1),
+					bytecode.Make(bytecode.OpReturnValue),
+				},
+			},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpClosure, 3, 0),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a = 1", "1:3: assignment to undeclared variable: a"},
+		{"len = 1", "1:5: cannot assign to len"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("Expected compiler error for %q, got none", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("Wrong compiler error. Wanted=%q, got=%q instead.", tt.expected, err)
+		}
+	}
+}
+
+func TestOperandLimits(t *testing.T) {
+	// name returns a distinct identifier for every i, identifiers can't contain digits
+	name := func(i int) string {
+		letters := []byte{}
+		for ; i >= 0; i = i/26 - 1 {
+			letters = append([]byte{byte('a' + i%26)}, letters...)
+		}
+		return "x_" + string(letters)
+	}
+	// repeated joins n items made by item with sep
+	repeated := func(n int, item func(int) string, sep string) string {
+		items := make([]string, n)
+		for i := range items {
+			items[i] = item(i)
+		}
+		return strings.Join(items, sep)
+	}
+	let := func(i int) string { return "let " + name(i) + " = 1;" }
+	letTrue := func(i int) string { return "let " + name(i) + " = true;" }
+	integer := func(i int) string { return fmt.Sprint(i) }
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn() { " + repeated(257, let, " ") + " }", "too many local variables (max 256)"},
+		{"fn(" + repeated(257, name, ", ") + ") { " + name(256) + " }", "too many local variables (max 256)"},
+		{"len(" + repeated(256, integer, ", ") + ")", "too many arguments (max 255)"},
+		{repeated(65537, integer, "; "), "too many constants (max 65536)"},
+		{repeated(65537, letTrue, " "), "too many global variables (max 65536)"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("Expected compiler error for %.40q, got none", tt.input)
+		}
+		if !strings.HasSuffix(err.Error(), tt.expected) {
+			t.Errorf("Wrong compiler error. Wanted=%q, got=%q instead.", tt.expected, err)
+		}
+	}
+
+	// the largest operands still compile
+	for _, input := range []string{
+		"fn() { " + repeated(256, let, " ") + " }",
+		"len(" + repeated(255, integer, ", ") + ")",
+	} {
+		if err := New().Compile(parse(input)); err != nil {
+			t.Errorf("Unexpected compiler error for %.40q: %s", input, err)
+		}
+	}
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"a ${1} b"`,
+			expectedConstants: []interface{}{"a ", 1, " b"},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpConstant, 0),
+				bytecode.Make(bytecode.OpConstant, 1),
+				bytecode.Make(bytecode.OpToString),
+				bytecode.Make(bytecode.OpConstant, 2),
+				bytecode.Make(bytecode.OpConcat, 3),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestLogicalExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []bytecode.Instructions{
+				// 0000
+				bytecode.Make(bytecode.OpTrue),
+				// 0001
+				bytecode.Make(bytecode.OpJumpNotTruthy, 10),
+				// 0004
+				bytecode.Make(bytecode.OpFalse),
+				// 0005
+				bytecode.Make(bytecode.OpBang),
+				// 0006
+				bytecode.Make(bytecode.OpBang),
+				// 0007
+				bytecode.Make(bytecode.OpJump, 11),
+				// 0010
+				bytecode.Make(bytecode.OpFalse),
+				// 0011
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+		{
+			input:             "false || true",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []bytecode.Instructions{
+				// 0000
+				bytecode.Make(bytecode.OpFalse),
+				// 0001
+				bytecode.Make(bytecode.OpJumpNotTruthy, 8),
+				// 0004
+				bytecode.Make(bytecode.OpTrue),
+				// 0005
+				bytecode.Make(bytecode.OpJump, 11),
+				// 0008
+				bytecode.Make(bytecode.OpTrue),
+				// 0009
+				bytecode.Make(bytecode.OpBang),
+				// 0010
+				bytecode.Make(bytecode.OpBang),
+				// 0011
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+		{
+			input:             "1 % 2 <= 3 >> ~4",
+			expectedConstants: []interface{}{1, 2, 3, 4},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpConstant, 0),
+				bytecode.Make(bytecode.OpConstant, 1),
+				bytecode.Make(bytecode.OpMod),
+				bytecode.Make(bytecode.OpConstant, 2),
+				bytecode.Make(bytecode.OpConstant, 3),
+				bytecode.Make(bytecode.OpBitNot),
+				bytecode.Make(bytecode.OpShiftRight),
+				bytecode.Make(bytecode.OpLessEqual),
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `1 + try { 2 } catch (e) { e }`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []bytecode.Instructions{
+				// 0000
+				bytecode.Make(bytecode.OpConstant, 0),
+				// 0003
+				bytecode.Make(bytecode.OpConstant, 1),
+				// 0006
+				bytecode.Make(bytecode.OpJump, 15),
+				// 0009
+				bytecode.Make(bytecode.OpSetGlobal, 0),
+				// 0012
+				bytecode.Make(bytecode.OpGetGlobal, 0),
+				// 0015
+				bytecode.Make(bytecode.OpAdd),
+				// 0016
+				bytecode.Make(bytecode.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+
+	compiler := New()
+	err := compiler.Compile(parse(`1 + try { try { 2 } catch (e) { 3 } } catch (e) { 4 }`))
+	if err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	// the inner handler comes first, both start with the 1 on the stack
+	expected := bytecode.ExceptionTable{
+		{Start: 3, End: 6, Handler: 9, StackDepth: 1},
+		{Start: 3, End: 15, Handler: 18, StackDepth: 1},
+	}
+	handlers := compiler.Bytecode().Handlers
+	if fmt.Sprint(handlers) != fmt.Sprint(expected) {
+		t.Errorf("Wrong exception table. Wanted=%v, got=%v instead.", expected, handlers)
+	}
+}
+
+func TestImportStatements(t *testing.T) {
+	module := filepath.Join(t.TempDir(), "lib.mk")
+	err := os.WriteFile(module, []byte("let x = 1;\nlet y = x;"), 0644)
+	if err != nil {
+		t.Fatalf("could not write module: %s", err)
+	}
+
+	tests := []compilerTestCase{
+		{
+			input: fmt.Sprintf("import %q as m; import %q as n", module, module),
+			expectedConstants: []interface{}{
+				1,
+				"x",
+				0,
+				"y",
+				1,
+				module,
+				[]bytecode.Instructions{
+					bytecode.Make(bytecode.OpConstant, 0),
+					bytecode.Make(bytecode.OpSetGlobal, 0),
+					bytecode.Make(bytecode.OpGetGlobal, 0),
+					bytecode.Make(bytecode.OpSetGlobal, 1),
+					bytecode.Make(bytecode.OpConstant, 1),
+					bytecode.Make(bytecode.OpConstant, 2),
+					bytecode.Make(bytecode.OpConstant, 3),
+					bytecode.Make(bytecode.OpConstant, 4),
+					bytecode.Make(bytecode.OpModule, 5, 2),
+					bytecode.Make(bytecode.OpReturnValue),
+				},
+			},
+			expectedInstructions: []bytecode.Instructions{
+				bytecode.Make(bytecode.OpImport, 2, 6),
+				bytecode.Make(bytecode.OpDup, 1),
+				bytecode.Make(bytecode.OpSetGlobal, 2),
+				bytecode.Make(bytecode.OpSetGlobal, 3),
+				bytecode.Make(bytecode.OpImport, 2, 6),
+				bytecode.Make(bytecode.OpDup, 1),
+				bytecode.Make(bytecode.OpSetGlobal, 2),
+				bytecode.Make(bytecode.OpSetGlobal, 4),
+			},
+		},
 	}
 	runCompilerTests(t, tests)
 }
@@ -0,0 +1,190 @@
+package compiler
+
+import (
+	"fmt"
+	"io"
+	"monkey-int/bytecode"
+	"monkey-int/object"
+	"monkey-int/token"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Disassemble writes a listing of the bytecode to w: the constant pool, followed by the
+// instructions of the main program and of every compiled function in it. Instructions
+// are annotated with the line of src they were compiled from, or with the file and line
+// for code of imported modules, whose source is read from disk.
+func Disassemble(w io.Writer, b *MyBytecode, src string) {
+	d := &disassembler{w: w, constants: b.Constants, sources: map[string][]string{}}
+	for _, pos := range b.SourceMap {
+		d.mainFile = pos.File
+		d.sources[pos.File] = strings.Split(src, "\n")
+		break
+	}
+
+	fmt.Fprintln(w, "== constants ==")
+	for i, constant := range b.Constants {
+		fmt.Fprintf(w, "%04d %s\n", i, d.describeConstant(constant))
+	}
+
+	main := &object.CompiledFunction{Instructions: b.Instructions, SourceMap: b.SourceMap, Handlers: b.Handlers}
+	d.function("<main>", main)
+	for i, constant := range b.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			header := fmt.Sprintf("fn %s (constant %d, %d parameters, %d locals)",
+				object.FunctionName(fn.Name), i, fn.NumParameters, fn.NumLocals)
+			d.function(header, fn)
+		}
+	}
+}
+
+type disassembler struct {
+	w         io.Writer
+	constants []object.Object
+	mainFile  string
+	sources   map[string][]string // source lines by file
+}
+
+func (d *disassembler) describeConstant(constant object.Object) string {
+	switch constant := constant.(type) {
+	case *object.String:
+		return fmt.Sprintf("%q", constant.Value)
+	case *object.CompiledFunction:
+		return "fn " + object.FunctionName(constant.Name)
+	}
+	return constant.Inspect()
+}
+
+// function writes the instructions of fn, jump targets and exception handlers are labeled
+func (d *disassembler) function(header string, fn *object.CompiledFunction) {
+	fmt.Fprintf(d.w, "\n== %s ==\n", header)
+	ins := fn.Instructions
+	labels := jumpLabels(fn)
+
+	var current token.Position
+	for i := 0; i < len(ins); {
+		if label, ok := labels[i]; ok {
+			fmt.Fprintf(d.w, "%s:\n", label)
+		}
+		if pos, ok := fn.SourceMap[i]; ok && (pos.Line != current.Line || pos.File != current.File) {
+			current = pos
+			line := fmt.Sprint(pos.Line)
+			if pos.File != d.mainFile {
+				line = pos.File + ":" + line
+			}
+			fmt.Fprintln(d.w, strings.TrimRight("     ; "+line+": "+d.sourceLine(pos), " "))
+		}
+
+		def, err := bytecode.Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(d.w, "%04d ERROR: %s\n", i, err)
+			i++
+			continue
+		}
+		if i+def.Width() > len(ins) {
+			fmt.Fprintf(d.w, "%04d ERROR: truncated %s\n", i, def.Name)
+			break
+		}
+		operands, _ := bytecode.ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(d.w, "%04d %s\n", i, d.instruction(bytecode.Opcode(ins[i]), def, operands, labels))
+		i += def.Width()
+	}
+	if label, ok := labels[len(ins)]; ok {
+		fmt.Fprintf(d.w, "%s:\n", label)
+	}
+
+	for _, h := range fn.Handlers {
+		fmt.Fprintf(d.w, "     try %s-%s catch %s (stack depth %d)\n", labels[h.Start], labels[h.End], labels[h.Handler], h.StackDepth)
+	}
+}
+
+// instruction formats an instruction with jump targets replaced by labels and a
+// comment resolving constants and builtins
+func (d *disassembler) instruction(op bytecode.Opcode, def *bytecode.Definition, operands []int, labels map[int]string) string {
+	args := make([]string, len(operands))
+	for i, operand := range operands {
+		args[i] = fmt.Sprint(operand)
+	}
+
+	comment := ""
+	switch op {
+	case bytecode.OpJump, bytecode.OpJumpNotTruthy:
+		args[0] = labels[operands[0]]
+	case bytecode.OpConstant:
+		comment = d.constant(operands[0])
+	case bytecode.OpClosure:
+		comment = fmt.Sprintf("%s, %d free", d.constant(operands[0]), operands[1])
+	case bytecode.OpModule:
+		comment = fmt.Sprintf("%s, %d exports", d.constant(operands[0]), operands[1])
+	case bytecode.OpImport:
+		comment = d.constant(operands[1])
+	case bytecode.OpGetBuiltin:
+		if operands[0] < len(object.Builtins) {
+			comment = object.Builtins[operands[0]].Name
+		}
+	}
+
+	text := strings.TrimSpace(def.Name + " " + strings.Join(args, " "))
+	if comment != "" {
+		text = fmt.Sprintf("%-24s ; %s", text, comment)
+	}
+	return text
+}
+
+func (d *disassembler) constant(index int) string {
+	if index >= len(d.constants) {
+		return "invalid constant"
+	}
+	return d.describeConstant(d.constants[index])
+}
+
+func (d *disassembler) sourceLine(pos token.Position) string {
+	lines, ok := d.sources[pos.File]
+	if !ok {
+		if src, err := os.ReadFile(pos.File); err == nil {
+			lines = strings.Split(string(src), "\n")
+		}
+		d.sources[pos.File] = lines
+	}
+	if pos.Line < 1 || pos.Line > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[pos.Line-1])
+}
+
+// jumpLabels names the jump targets and exception handler boundaries of fn in order of their offset
+func jumpLabels(fn *object.CompiledFunction) map[int]string {
+	targets := map[int]bool{}
+	ins := fn.Instructions
+	for i := 0; i < len(ins); {
+		def, err := bytecode.Lookup(ins[i])
+		if err != nil {
+			i++
+			continue
+		}
+		if i+def.Width() > len(ins) {
+			break
+		}
+		op := bytecode.Opcode(ins[i])
+		if op == bytecode.OpJump || op == bytecode.OpJumpNotTruthy {
+			targets[int(bytecode.ReadUint16(ins[i+1:]))] = true
+		}
+		i += def.Width()
+	}
+	for _, h := range fn.Handlers {
+		targets[h.Start], targets[h.End], targets[h.Handler] = true, true, true
+	}
+
+	offsets := make([]int, 0, len(targets))
+	for offset := range targets {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	labels := make(map[int]string, len(offsets))
+	for i, offset := range offsets {
+		labels[offset] = fmt.Sprintf("L%d", i+1)
+	}
+	return labels
+}
@@ -0,0 +1,48 @@
+package compiler
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := "let f = fn(x) { if (x) { \"yes\" } };\nf(true);"
+
+	compiler := New()
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+
+	expected := `== constants ==
+0000 "yes"
+0001 fn f
+
+== <main> ==
+     ; 1: let f = fn(x) { if (x) { "yes" } };
+0000 OpClosure 1 0            ; fn f, 0 free
+0004 OpSetGlobal 0
+     ; 2: f(true);
+0007 OpGetGlobal 0
+0010 OpTrue
+0011 OpCall 1
+0013 OpPop
+
+== fn f (constant 1, 1 parameters, 1 locals) ==
+     ; 1: let f = fn(x) { if (x) { "yes" } };
+0000 OpGetLocal 0
+0002 OpJumpNotTruthy L1
+0005 OpConstant 0             ; "yes"
+0008 OpJump L2
+L1:
+0011 OpNull
+L2:
+0012 OpReturnValue
+`
+
+	var out bytes.Buffer
+	Disassemble(&out, compiler.Bytecode(), input)
+	if out.String() != expected {
+		t.Errorf("Wrong disassembly.\nWanted=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
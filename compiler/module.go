@@ -0,0 +1,93 @@
+package compiler
+
+import (
+	"monkey-int/ast"
+	"monkey-int/bytecode"
+	"monkey-int/object"
+	"monkey-int/parser"
+	"path/filepath"
+)
+
+// compileImportStatement binds the module object to the import name. The first import of
+// a module file compiles it into a constant, a function running the top level of the module.
+// Every import of the file reads the module object from a hidden global with OpImport, which
+// calls the module function while the global is unset, and keeps it there for later imports.
+func (c *Compiler) compileImportStatement(node *ast.ImportStatement) error {
+	file := node.ModuleFile()
+	globals := c.symbolTable.globals
+
+	cached, ok := globals.modules[file]
+	if !ok {
+		if len(globals.importing) == 0 {
+			globals.importing = []string{filepath.Clean(node.Pos().File)}
+			defer func() { globals.importing = nil }()
+		}
+		if err := object.ImportCycle(globals.importing, file); err != nil {
+			return c.errorf("%s", err)
+		}
+		src, err := c.Sandbox.ReadFile(file)
+		if err != nil {
+			return c.errorf("could not import %q: %s", node.Path, err)
+		}
+		program, err := parser.ParseFile(file, src)
+		if err != nil {
+			return c.errorf("could not import %q: %s", node.Path, err)
+		}
+
+		globals.importing = append(globals.importing, file)
+		fn, err := c.compileModule(program, node.Path)
+		globals.importing = globals.importing[:len(globals.importing)-1]
+		if err != nil {
+			return err
+		}
+
+		cached = compiledModule{global: c.symbolTable.defineHidden(file), fn: c.addConstant(fn)}
+		globals.modules[file] = cached
+	}
+
+	c.emit(bytecode.OpImport, cached.global.Index, cached.fn)
+	c.emit(bytecode.OpDup, 1)
+	c.storeSymbol(cached.global)
+	symbol := c.symbolTable.Define(node.Name.Value)
+	c.storeSymbol(symbol)
+	return nil
+}
+
+// compileModule compiles the top level of a module with its own global symbol table into
+// a function that returns the module object:
+//
+//	<module statements>
+//	OpConstant name; OpConstant "export"; OpConstant <global index of export> ...
+//	OpModule name exports
+//	OpReturnValue
+//
+// OpModule reads the exports from the globals itself, as a return at the top level of the
+// module can leave some of them unset
+func (c *Compiler) compileModule(program *ast.Program, name string) (*object.CompiledFunction, error) {
+	module := NewWithState(NewModuleSymbolTable(c.symbolTable), c.constants)
+	module.Sandbox = c.Sandbox
+	err := module.Compile(program)
+	if err != nil {
+		return nil, err
+	}
+
+	exports := program.Exports()
+	for _, export := range exports {
+		symbol, _ := module.symbolTable.Resolve(export)
+		module.emit(bytecode.OpConstant, module.addConstant(&object.String{Value: export}))
+		module.emit(bytecode.OpConstant, module.addConstant(&object.Integer{Value: int64(symbol.Index)}))
+	}
+	module.emit(bytecode.OpModule, module.addConstant(&object.String{Value: name}), len(exports))
+	module.emit(bytecode.OpReturnValue)
+	c.constants = module.constants
+	if module.err != nil {
+		return nil, module.err
+	}
+
+	return &object.CompiledFunction{
+		Name:         object.ModuleFunctionName(name),
+		Instructions: module.currentInstructions(),
+		SourceMap:    module.scopes[0].sourceMap,
+		Handlers:     module.currentHandlers(),
+	}, nil
+}
@@ -0,0 +1,306 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"monkey-int/bytecode"
+	"monkey-int/object"
+	"monkey-int/token"
+	"sort"
+)
+
+// Compiled files start with the magic bytes and the format version, followed by the length
+// of the payload, the payload itself and the CRC-32 checksum of everything before it.
+// The version has to be increased whenever the encoding or the instruction set changes.
+const (
+	bytecodeMagic   = "\x7fMKC"
+	BytecodeVersion = 5
+)
+
+// constant pool tags
+const (
+	tagInteger byte = iota + 1
+	tagFloat
+	tagString
+	tagFunction
+)
+
+var errTruncated = errors.New("truncated bytecode file")
+
+// IsSerializedBytecode reports whether data starts like a file written by MarshalBinary
+func IsSerializedBytecode(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(bytecodeMagic))
+}
+
+// MarshalBinary encodes the bytecode in the versioned on-disk format
+func (b *MyBytecode) MarshalBinary() ([]byte, error) {
+	e := &encoder{files: map[string]int{}}
+	e.function(&object.CompiledFunction{Instructions: b.Instructions, SourceMap: b.SourceMap, Handlers: b.Handlers})
+	e.strings(b.GlobalNames)
+	e.uvarint(len(b.Constants))
+	for i, constant := range b.Constants {
+		if err := e.constant(constant); err != nil {
+			return nil, fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+
+	// file names are stored once, the source maps refer to them by index
+	var payload []byte
+	payload = binary.AppendUvarint(payload, uint64(len(e.fileNames)))
+	for _, name := range e.fileNames {
+		payload = appendString(payload, name)
+	}
+	payload = append(payload, e.buf...)
+
+	out := []byte(bytecodeMagic)
+	out = binary.BigEndian.AppendUint16(out, BytecodeVersion)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(payload)))
+	out = append(out, payload...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(out)), nil
+}
+
+// UnmarshalBinary decodes bytecode written by MarshalBinary
+func (b *MyBytecode) UnmarshalBinary(data []byte) error {
+	headerLen := len(bytecodeMagic) + 2 + 4
+	if !IsSerializedBytecode(data) {
+		return errors.New("not a compiled Monkey file")
+	}
+	if len(data) < headerLen+4 {
+		return errTruncated
+	}
+	if version := binary.BigEndian.Uint16(data[len(bytecodeMagic):]); version != BytecodeVersion {
+		return fmt.Errorf("unsupported bytecode version %d, want %d", version, BytecodeVersion)
+	}
+	payloadLen := int(binary.BigEndian.Uint32(data[len(bytecodeMagic)+2:]))
+	if len(data) != headerLen+payloadLen+4 {
+		return errTruncated
+	}
+	checksumAt := headerLen + payloadLen
+	if crc32.ChecksumIEEE(data[:checksumAt]) != binary.BigEndian.Uint32(data[checksumAt:]) {
+		return errors.New("bytecode checksum mismatch, the file is corrupted")
+	}
+
+	d := &decoder{buf: data[headerLen:checksumAt]}
+	numFiles := d.uvarint()
+	for i := 0; i < numFiles && d.err == nil; i++ {
+		d.files = append(d.files, d.string())
+	}
+	main := d.function()
+	globalNames := d.strings()
+	numConstants := d.uvarint()
+	constants := []object.Object{}
+	for i := 0; i < numConstants && d.err == nil; i++ {
+		constants = append(constants, d.constant())
+	}
+	if d.err == nil && len(d.buf) > 0 {
+		d.err = errors.New("unexpected data after the constant pool")
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	b.Instructions = main.Instructions
+	b.SourceMap = main.SourceMap
+	b.Handlers = main.Handlers
+	b.GlobalNames = globalNames
+	b.Constants = constants
+	return nil
+}
+
+type encoder struct {
+	buf       []byte
+	files     map[string]int
+	fileNames []string
+}
+
+func (e *encoder) uvarint(v int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(v))
+}
+
+func (e *encoder) constant(constant object.Object) error {
+	switch constant := constant.(type) {
+	case *object.Integer:
+		e.buf = append(e.buf, tagInteger)
+		e.buf = binary.AppendVarint(e.buf, constant.Value)
+	case *object.Float:
+		e.buf = append(e.buf, tagFloat)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(constant.Value))
+	case *object.String:
+		e.buf = append(e.buf, tagString)
+		e.buf = appendString(e.buf, constant.Value)
+	case *object.CompiledFunction:
+		e.buf = append(e.buf, tagFunction)
+		e.buf = appendString(e.buf, constant.Name)
+		e.uvarint(constant.NumParameters)
+		e.uvarint(constant.NumLocals)
+		e.function(constant)
+	default:
+		return fmt.Errorf("cannot serialize %s", constant.Type())
+	}
+	return nil
+}
+
+// function encodes the instructions, source map, exception table and variable names of fn
+func (e *encoder) function(fn *object.CompiledFunction) {
+	e.buf = appendString(e.buf, string(fn.Instructions))
+
+	offsets := make([]int, 0, len(fn.SourceMap))
+	for offset := range fn.SourceMap {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+	e.uvarint(len(offsets))
+	for _, offset := range offsets {
+		pos := fn.SourceMap[offset]
+		file, ok := e.files[pos.File]
+		if !ok {
+			file = len(e.fileNames)
+			e.files[pos.File] = file
+			e.fileNames = append(e.fileNames, pos.File)
+		}
+		e.uvarint(offset)
+		e.uvarint(file)
+		e.uvarint(pos.Line)
+		e.uvarint(pos.Column)
+	}
+
+	e.uvarint(len(fn.Handlers))
+	for _, h := range fn.Handlers {
+		e.uvarint(h.Start)
+		e.uvarint(h.End)
+		e.uvarint(h.Handler)
+		e.uvarint(h.StackDepth)
+	}
+
+	e.strings(fn.LocalNames)
+	e.strings(fn.FreeNames)
+}
+
+func (e *encoder) strings(values []string) {
+	e.uvarint(len(values))
+	for _, value := range values {
+		e.buf = appendString(e.buf, value)
+	}
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// decoder reads the payload, the first error stops all further reads
+type decoder struct {
+	buf   []byte
+	files []string
+	err   error
+}
+
+func (d *decoder) uvarint() int {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 || v > math.MaxInt32 {
+		d.err = errTruncated
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return int(v)
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.buf) {
+		d.err = errTruncated
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes(d.uvarint()))
+}
+
+func (d *decoder) strings() []string {
+	n := d.uvarint()
+	var values []string
+	for i := 0; i < n && d.err == nil; i++ {
+		values = append(values, d.string())
+	}
+	return values
+}
+
+func (d *decoder) constant() object.Object {
+	tag := d.bytes(1)
+	if d.err != nil {
+		return nil
+	}
+
+	switch tag[0] {
+	case tagInteger:
+		v, n := binary.Varint(d.buf)
+		if n <= 0 {
+			d.err = errTruncated
+			return nil
+		}
+		d.buf = d.buf[n:]
+		return &object.Integer{Value: v}
+	case tagFloat:
+		bits := d.bytes(8)
+		if d.err != nil {
+			return nil
+		}
+		return &object.Float{Value: math.Float64frombits(binary.BigEndian.Uint64(bits))}
+	case tagString:
+		return &object.String{Value: d.string()}
+	case tagFunction:
+		name := d.string()
+		numParameters := d.uvarint()
+		numLocals := d.uvarint()
+		fn := d.function()
+		fn.Name, fn.NumParameters, fn.NumLocals = name, numParameters, numLocals
+		return fn
+	}
+	d.err = fmt.Errorf("unknown constant tag %d", tag[0])
+	return nil
+}
+
+func (d *decoder) function() *object.CompiledFunction {
+	fn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions(d.string()),
+		SourceMap:    bytecode.SourceMap{},
+	}
+
+	numPositions := d.uvarint()
+	for i := 0; i < numPositions && d.err == nil; i++ {
+		offset, file, line, column := d.uvarint(), d.uvarint(), d.uvarint(), d.uvarint()
+		if d.err == nil && file >= len(d.files) {
+			d.err = fmt.Errorf("invalid file index %d", file)
+		}
+		if d.err == nil {
+			fn.SourceMap[offset] = token.Position{File: d.files[file], Line: line, Column: column}
+		}
+	}
+
+	numHandlers := d.uvarint()
+	for i := 0; i < numHandlers && d.err == nil; i++ {
+		fn.Handlers = append(fn.Handlers, bytecode.ExceptionHandler{
+			Start:      d.uvarint(),
+			End:        d.uvarint(),
+			Handler:    d.uvarint(),
+			StackDepth: d.uvarint(),
+		})
+	}
+
+	fn.LocalNames = d.strings()
+	fn.FreeNames = d.strings()
+	return fn
+}
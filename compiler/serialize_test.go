@@ -0,0 +1,96 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"monkey-int/object"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSerializeRoundTrip(t *testing.T) {
+	input := `let f = fn(x, y) { let z = x * 2.5; try { throw("no") } catch (e) { z + y } };
+let s = "héllo";
+puts(f(-70000, 1), s, [1, 2][0], {"a": true});`
+
+	compiler := New()
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	original := compiler.Bytecode()
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %s", err)
+	}
+	if !IsSerializedBytecode(data) {
+		t.Fatalf("Serialized bytecode not recognized.")
+	}
+
+	loaded := &MyBytecode{}
+	err = loaded.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf("UnmarshalBinary failed: %s", err)
+	}
+
+	var want, got bytes.Buffer
+	Disassemble(&want, original, input)
+	Disassemble(&got, loaded, input)
+	if want.String() != got.String() {
+		t.Errorf("Loaded bytecode differs.\nWanted=%s\ngot=%s instead.", want.String(), got.String())
+	}
+	if !reflect.DeepEqual(original.SourceMap, loaded.SourceMap) {
+		t.Errorf("Source map differs. Wanted=%v, got=%v instead.", original.SourceMap, loaded.SourceMap)
+	}
+	if !slices.Equal(original.GlobalNames, loaded.GlobalNames) {
+		t.Errorf("Global names differ. Wanted=%q, got=%q instead.", original.GlobalNames, loaded.GlobalNames)
+	}
+	for i, constant := range original.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		loadedFn := loaded.Constants[i].(*object.CompiledFunction)
+		if !slices.Equal(fn.LocalNames, loadedFn.LocalNames) || !slices.Equal(fn.FreeNames, loadedFn.FreeNames) {
+			t.Errorf("Variable names of constant %d differ. Wanted=%q %q, got=%q %q instead.",
+				i, fn.LocalNames, fn.FreeNames, loadedFn.LocalNames, loadedFn.FreeNames)
+		}
+	}
+}
+
+func TestDeserializeErrors(t *testing.T) {
+	compiler := New()
+	err := compiler.Compile(parse(`let x = "abc"; fn() { x }`))
+	if err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	data, err := compiler.Bytecode().MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %s", err)
+	}
+
+	modify := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte{}, data...))
+	}
+
+	tests := []struct {
+		data          []byte
+		expectedError string
+	}{
+		{[]byte("let x = 1;"), "not a compiled Monkey file"},
+		{data[:len(data)-1], "truncated bytecode file"},
+		{data[:6], "truncated bytecode file"},
+		{modify(func(b []byte) []byte { b[5]++; return b }), fmt.Sprintf("unsupported bytecode version %d, want %d", BytecodeVersion+1, BytecodeVersion)},
+		{modify(func(b []byte) []byte { b[len(b)/2] ^= 0xFF; return b }), "bytecode checksum mismatch"},
+	}
+
+	for _, tt := range tests {
+		err := (&MyBytecode{}).UnmarshalBinary(tt.data)
+		if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+			t.Errorf("Wrong error. Wanted=%q, got=%v instead.", tt.expectedError, err)
+		}
+	}
+}
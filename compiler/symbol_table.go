@@ -1,9 +1,14 @@
 package compiler
 
+import "monkey-int/object"
+
 type SymbolScope string
 
 const (
-	GlobalScope SymbolScope = "GLOBAL"
+	GlobalScope  SymbolScope = "GLOBAL"
+	LocalScope   SymbolScope = "LOCAL"
+	FreeScope    SymbolScope = "FREE"
+	BuiltinScope SymbolScope = "BUILTIN"
 )
 
 type Symbol struct {
@@ -13,23 +18,152 @@ type Symbol struct {
 }
 
 type SymbolTable struct {
+	Outer *SymbolTable
+
 	store          map[string]Symbol
 	numDefinitions int
+	localNames     []string // names of the locals of a function scope, by index
+
+	// symbols of enclosing (non-global) scopes captured by this scope, in capture order
+	FreeSymbols []Symbol
+
+	globals *globalState
+}
+
+// globalState is shared by the global symbol tables of a program and the modules it imports,
+// whose globals all live in the same globals store
+type globalState struct {
+	numGlobals int
+	names      []string                  // names of the globals by index, hidden globals are named by their file
+	modules    map[string]compiledModule // modules compiled so far, by module file
+	importing  []string                  // file of the main program and of the modules being compiled, outermost first
+}
+
+// compiledModule is a module file compiled into the module function constant fn, whose
+// result is kept in the hidden global
+type compiledModule struct {
+	global Symbol
+	fn     int
 }
 
 func NewSymbolTable() *SymbolTable {
 	s := make(map[string]Symbol)
-	return &SymbolTable{store: s}
+	free := []Symbol{}
+	globals := &globalState{modules: map[string]compiledModule{}}
+	return &SymbolTable{store: s, FreeSymbols: free, globals: globals}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := make(map[string]Symbol)
+	free := []Symbol{}
+	return &SymbolTable{Outer: outer, store: s, FreeSymbols: free, globals: outer.globals}
+}
+
+// NewModuleSymbolTable returns the global symbol table of a module imported by the program
+// of s: it knows the builtins and allocates its globals after the ones of the program
+func NewModuleSymbolTable(s *SymbolTable) *SymbolTable {
+	module := NewSymbolTable()
+	module.globals = s.globals
+	for i, v := range object.Builtins {
+		module.DefineBuiltin(i, v.Name)
+	}
+	return module
+}
+
+// Copy returns a global symbol table with the symbols of s, that can be defined in without
+// affecting s. Compiling against a copy lets callers discard the symbols of a failed compilation.
+func (s *SymbolTable) Copy() *SymbolTable {
+	store := make(map[string]Symbol, len(s.store))
+	for name, symbol := range s.store {
+		store[name] = symbol
+	}
+	modules := make(map[string]compiledModule, len(s.globals.modules))
+	for file, module := range s.globals.modules {
+		modules[file] = module
+	}
+	names := append([]string{}, s.globals.names...)
+	globals := &globalState{numGlobals: s.globals.numGlobals, names: names, modules: modules}
+	return &SymbolTable{store: store, numDefinitions: s.numDefinitions, FreeSymbols: []Symbol{}, globals: globals}
 }
 
 func (s *SymbolTable) Define(name string) Symbol {
-	symbol := Symbol{Name: name, Index: s.numDefinitions, Scope: GlobalScope}
+	symbol := Symbol{Name: name, Index: s.numDefinitions}
+	if s.Outer == nil {
+		symbol.Scope = GlobalScope
+		symbol.Index = s.globals.numGlobals
+	} else {
+		symbol.Scope = LocalScope
+	}
+	// redefining a name in the same scope reuses its slot
+	if existing, ok := s.store[name]; ok && existing.Scope == symbol.Scope {
+		return existing
+	}
 	s.store[name] = symbol
 	s.numDefinitions++
+	if symbol.Scope == GlobalScope {
+		s.globals.numGlobals++
+		s.globals.names = append(s.globals.names, name)
+	} else {
+		s.localNames = append(s.localNames, name)
+	}
+	return symbol
+}
+
+// GlobalNames returns the names of the globals defined so far by the program of s and the
+// modules it imports, by index
+func (s *SymbolTable) GlobalNames() []string {
+	return s.globals.names
+}
+
+// defineHidden allocates a global that cannot be referred to by name
+func (s *SymbolTable) defineHidden(name string) Symbol {
+	symbol := Symbol{Name: name, Scope: GlobalScope, Index: s.globals.numGlobals}
+	s.globals.numGlobals++
+	s.globals.names = append(s.globals.names, name)
+	return symbol
+}
+
+// shadow binds name to symbol until the returned function restores the previous binding of
+// name in s
+func (s *SymbolTable) shadow(name string, symbol Symbol) (restore func()) {
+	previous, ok := s.store[name]
+	s.store[name] = symbol
+	return func() {
+		if ok {
+			s.store[name] = previous
+		} else {
+			delete(s.store, name)
+		}
+	}
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope}
+	s.store[original.Name] = symbol
 	return symbol
 }
 
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
+	if !ok && s.Outer != nil {
+		obj, ok = s.Outer.Resolve(name)
+		if !ok {
+			return obj, ok
+		}
+		if obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+			return obj, ok
+		}
+		// locals of an enclosing function have to be captured by the closure
+		free := s.defineFree(obj)
+		return free, true
+	}
 	return obj, ok
 }
@@ -42,3 +42,185 @@ func TestResolveGlobal(t *testing.T) {
 		}
 	}
 }
+
+func TestResolveLocal(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("c")
+	local.Define("d")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: GlobalScope, Index: 1},
+		{Name: "c", Scope: LocalScope, Index: 0},
+		{Name: "d", Scope: LocalScope, Index: 1},
+	}
+
+	for _, sym := range expected {
+		result, ok := local.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("Name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("Expected %s to resolve to %+v, got=%+v instead.", sym.Name, sym, result)
+		}
+	}
+}
+
+func TestResolveNestedLocal(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("b")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("c")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: FreeScope, Index: 0},
+		{Name: "c", Scope: LocalScope, Index: 0},
+	}
+
+	for _, sym := range expected {
+		result, ok := secondLocal.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("Name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("Expected %s to resolve to %+v, got=%+v instead.", sym.Name, sym, result)
+		}
+	}
+}
+
+func TestResolveFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("c")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("e")
+	secondLocal.Define("f")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "c", Scope: FreeScope, Index: 0},
+		{Name: "e", Scope: LocalScope, Index: 0},
+		{Name: "f", Scope: LocalScope, Index: 1},
+	}
+
+	for _, sym := range expected {
+		result, ok := secondLocal.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("Name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("Expected %s to resolve to %+v, got=%+v instead.", sym.Name, sym, result)
+		}
+	}
+
+	expectedFree := []Symbol{
+		{Name: "c", Scope: LocalScope, Index: 0},
+	}
+	if len(secondLocal.FreeSymbols) != len(expectedFree) {
+		t.Fatalf("Wrong number of free symbols. Got=%d, wanted=%d", len(secondLocal.FreeSymbols), len(expectedFree))
+	}
+	for i, sym := range expectedFree {
+		if secondLocal.FreeSymbols[i] != sym {
+			t.Errorf("Wrong free symbol. Got=%+v, wanted=%+v", secondLocal.FreeSymbols[i], sym)
+		}
+	}
+}
+
+func TestResolveUnresolvableFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("c")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("e")
+
+	for _, name := range []string{"b", "d"} {
+		_, ok := secondLocal.Resolve(name)
+		if ok {
+			t.Errorf("Name %s resolved, but was expected not to", name)
+		}
+	}
+}
+
+func TestDefineResolveBuiltins(t *testing.T) {
+	global := NewSymbolTable()
+	firstLocal := NewEnclosedSymbolTable(global)
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+
+	expected := []Symbol{
+		{Name: "a", Scope: BuiltinScope, Index: 0},
+		{Name: "c", Scope: BuiltinScope, Index: 1},
+		{Name: "e", Scope: BuiltinScope, Index: 2},
+		{Name: "f", Scope: BuiltinScope, Index: 3},
+	}
+
+	for i, v := range expected {
+		global.DefineBuiltin(i, v.Name)
+	}
+
+	for _, table := range []*SymbolTable{global, firstLocal, secondLocal} {
+		for _, sym := range expected {
+			result, ok := table.Resolve(sym.Name)
+			if !ok {
+				t.Errorf("Name %s not resolvable", sym.Name)
+				continue
+			}
+			if result != sym {
+				t.Errorf("Expected %s to resolve to %+v, got=%+v instead.", sym.Name, sym, result)
+			}
+		}
+	}
+}
+
+func TestRedefineReusesSlot(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	expected := Symbol{Name: "a", Scope: GlobalScope, Index: 0}
+	if result := global.Define("a"); result != expected {
+		t.Errorf("Expected redefinition of a to be %+v, got=%+v instead.", expected, result)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	expected = Symbol{Name: "a", Scope: LocalScope, Index: 0}
+	if result := local.Define("a"); result != expected {
+		t.Errorf("Expected local a to be %+v, got=%+v instead.", expected, result)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	copied := global.Copy()
+	expected := Symbol{Name: "b", Scope: GlobalScope, Index: 1}
+	if result := copied.Define("b"); result != expected {
+		t.Errorf("Expected b of the copy to be %+v, got=%+v instead.", expected, result)
+	}
+	if _, ok := global.Resolve("b"); ok {
+		t.Errorf("Expected b not to be defined in the original")
+	}
+
+	expected = Symbol{Name: "c", Scope: GlobalScope, Index: 1}
+	if result := global.Define("c"); result != expected {
+		t.Errorf("Expected c of the original to be %+v, got=%+v instead.", expected, result)
+	}
+}
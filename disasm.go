@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"monkey-int/compiler"
+	"os"
+)
+
+// disasmFile prints the bytecode of a script, or of a compiled .mkc file without the source lines
+func disasmFile(filename string, out, errOut io.Writer) int {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(errOut, "could not read %s: %s\n", filename, err)
+		return 1
+	}
+
+	if compiler.IsSerializedBytecode(src) {
+		bytecode := &compiler.MyBytecode{}
+		if err := bytecode.UnmarshalBinary(src); err != nil {
+			fmt.Fprintf(errOut, "could not load %s: %s\n", filename, err)
+			return 1
+		}
+		compiler.Disassemble(out, bytecode, "")
+		return 0
+	}
+
+	program, ok := parseSource(filename, string(src), errOut)
+	if !ok {
+		return 1
+	}
+
+	bytecode, ok := compileScript(program, errOut)
+	if !ok {
+		return 1
+	}
+
+	compiler.Disassemble(out, bytecode, string(src))
+	return 0
+}
@@ -1,98 +1,197 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"monkey-int/ast"
 	"monkey-int/object"
+	"strings"
+	"unicode/utf8"
 )
 
 var (
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
-	NULL  = &object.Null{}
+	TRUE     = object.TRUE
+	FALSE    = object.FALSE
+	NULL     = object.NULL
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
+// Eval evaluates node within ctx. A Go panic raised during evaluation, which means
+// the interpreter itself has a bug, is returned as an error with the Monkey call stack.
 func Eval(node ast.Node, ctx *object.Context) object.Object {
+	return EvalWithBudget(context.Background(), node, ctx, object.Budget{})
+}
+
+// EvalWithBudget is like Eval, but aborts the evaluation with an error of kind
+// BudgetExceededKind once it exceeds budget or goCtx is done
+func EvalWithBudget(goCtx context.Context, node ast.Node, ctx *object.Context, budget object.Budget) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = internalError(r)
+		}
+	}()
+	if err := ctx.Meter().Start(goCtx, budget); err != nil {
+		return internalError(err)
+	}
+	result = eval(node, ctx)
+	if err, ok := result.(*object.Error); ok {
+		err.Stack = callStack(err, ctx)
+	}
+	return result
+}
+
+// Apply calls a function or builtin of the program evaluated within ctx with args from outside
+// of any Monkey code, errors are returned with their call stack like by Eval
+func Apply(fn object.Object, args []object.Object, ctx *object.Context) object.Object {
+	return ApplyWithBudget(context.Background(), fn, args, ctx, object.Budget{})
+}
+
+// ApplyWithBudget is like Apply, but limits the call like EvalWithBudget
+func ApplyWithBudget(goCtx context.Context, fn object.Object, args []object.Object, ctx *object.Context, budget object.Budget) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = internalError(r)
+		}
+	}()
+	if err := ctx.Meter().Start(goCtx, budget); err != nil {
+		return internalError(err)
+	}
+	result = applyFunction(fn, args, ctx)
+	if err, ok := result.(*object.Error); ok {
+		err.Stack = append(err.Stack, object.StackFrame{Function: "<main>"})
+	}
+	return result
+}
+
+func eval(node ast.Node, ctx *object.Context) object.Object {
+	defer annotatePanic(node)
+	if err := ctx.Meter().Step(); err != nil {
+		panic(err)
+	}
+
+	result := evalNode(node, ctx)
+	// errors are tagged with the innermost node they originate from
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+	return result
+}
+
+func evalNode(node ast.Node, ctx *object.Context) object.Object {
 	switch node := node.(type) {
 	// Statements
 	case *ast.Program:
 		return evalProgram(node.Statements, ctx)
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, ctx)
+		return eval(node.Expression, ctx)
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, ctx)
-		if isError(val) {
+		val := eval(node.ReturnValue, ctx)
+		if isAbrupt(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, ctx)
 	case *ast.LetStatement:
-		val := Eval(node.Value, ctx)
-		if isError(val) {
+		val := eval(node.Value, ctx)
+		if isAbrupt(val) {
 			return val
 		}
 		ctx.Set(node.Name.Value, val)
+	case *ast.ImportStatement:
+		module := evalImportStatement(node, ctx)
+		if isAbrupt(module) {
+			return module
+		}
+		ctx.Set(node.Name.Value, module)
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, ctx)
+	case *ast.ForStatement:
+		return evalForStatement(node, ctx)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	// Expressions
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.InterpolatedString:
+		return evalInterpolatedString(node, ctx)
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, ctx)
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, ctx)
-		if isError(right) {
+		right := eval(node.Right, ctx)
+		if isAbrupt(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return evalPrefixExpression(node.Operator, right, ctx)
 	case *ast.InfixExpression:
-		left := Eval(node.Left, ctx)
-		if isError(left) {
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, ctx)
+		}
+		left := eval(node.Left, ctx)
+		if isAbrupt(left) {
 			return left
 		}
-		right := Eval(node.Right, ctx)
-		if isError(right) {
+		right := eval(node.Right, ctx)
+		if isAbrupt(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return evalInfixExpression(node.Operator, left, right, ctx)
 	case *ast.IfExpression:
 		return evalIfExpression(node, ctx)
+	case *ast.TryExpression:
+		return evalTryExpression(node, ctx)
 	case *ast.Identifier:
 		return evalIdentifier(node, ctx)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Ctx: ctx, Body: body}
+		return &object.Function{Name: node.Name, Parameters: params, Ctx: ctx, Body: body}
 	case *ast.CallExpression:
-		function := Eval(node.Function, ctx)
-		if isError(function) {
+		function := eval(node.Function, ctx)
+		if isAbrupt(function) {
 			return function
 		}
 		args := evalExpressions(node.Arguments, ctx)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isAbrupt(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		result := applyFunction(function, args, ctx)
+		if builtin, ok := function.(*object.Builtin); ok && builtin.Created(result, args) {
+			allocated(result, ctx)
+		}
+		if err, ok := result.(*object.Error); ok && len(err.Stack) > 0 {
+			// the error was raised within the called function, record the call site
+			err.Stack = append(err.Stack, object.StackFrame{Function: ctx.FunctionName(), Pos: node.Pos()})
+		}
+		return result
 	case *ast.ArrayLiteral:
-		var els []object.Object
+		allocate(object.ArraySize(len(node.Elements)), ctx)
+		els := make([]object.Object, 0, len(node.Elements))
 		for _, el := range node.Elements {
-			evalEl := Eval(el, ctx)
-			if isError(evalEl) {
+			evalEl := eval(el, ctx)
+			if isAbrupt(evalEl) {
 				return evalEl
 			}
 			els = append(els, evalEl)
 		}
 		return &object.Array{Elements: els}
 	case *ast.IndexExpression:
-		left := Eval(node.Left, ctx)
-		if isError(left) {
+		left := eval(node.Left, ctx)
+		if isAbrupt(left) {
 			return left
 		}
-		index := Eval(node.Index, ctx)
-		if isError(index) {
+		index := eval(node.Index, ctx)
+		if isAbrupt(index) {
 			return index
 		}
 		switch {
@@ -100,9 +199,26 @@ func Eval(node ast.Node, ctx *object.Context) object.Object {
 			return evalArrayIndexExpression(left, index)
 		case left.Type() == object.HASH_OBJ:
 			return evalHashIndexExpression(left, index)
+		case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+			return evalStringIndexExpression(left, index, ctx)
+		case left.Type() == object.ERROR_VALUE_OBJ:
+			errorValue := left.(*object.ErrorValue)
+			allocate(errorValue.FieldSize(index), ctx)
+			if field, ok := errorValue.Field(index); ok {
+				return field
+			}
+			return NULL
+		case left.Type() == object.MODULE_OBJ:
+			member, err := left.(*object.Module).Member(index)
+			if err != nil {
+				return newError("%s", err)
+			}
+			return member
 		default:
 			return newError("index operator not supported: %s", left.Type())
 		}
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, ctx)
 	}
 	return nil
 }
@@ -110,7 +226,7 @@ func Eval(node ast.Node, ctx *object.Context) object.Object {
 func evalProgram(statements []ast.Statement, ctx *object.Context) object.Object {
 	var result object.Object
 	for _, statement := range statements {
-		result = Eval(statement, ctx)
+		result = eval(statement, ctx)
 
 		if returnValue, ok := result.(*object.ReturnValue); ok {
 			return returnValue.Value
@@ -131,12 +247,17 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	}
 }
 
-func evalPrefixExpression(operator string, right object.Object) object.Object {
+func evalPrefixExpression(operator string, right object.Object, ctx *object.Context) object.Object {
 	switch operator {
 	case "!":
 		return evalBangOperatorExpression(right)
 	case "-":
-		return evalMinusPrefixOperatorExpression(right)
+		return evalMinusPrefixOperatorExpression(right, ctx)
+	case "~":
+		if r, ok := right.(*object.Integer); ok {
+			return &object.Integer{Value: ^r.Value}
+		}
+		return newError("unknown operator: ~%s", right.Type())
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
@@ -155,23 +276,32 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 	}
 }
 
-func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
+func evalMinusPrefixOperatorExpression(right object.Object, ctx *object.Context) object.Object {
 	switch r := right.(type) {
 	case *object.Integer:
-		return &object.Integer{Value: -r.Value}
+		value, ok := object.NegInt64(r.Value)
+		if ctx.CheckedArithmetic && !ok {
+			return newError("integer overflow: -%d", r.Value)
+		}
+		return &object.Integer{Value: value}
+	case *object.Float:
+		return &object.Float{Value: -r.Value}
 	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
 }
 
-func evalInfixExpression(operator string, left, right object.Object) object.Object {
+func evalInfixExpression(operator string, left, right object.Object, ctx *object.Context) object.Object {
 	switch {
 	// check for integers before checking for booleans because booleans are only
 	// evaluated with pointer comparison, not object comparison
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-		return evalIntegerInfixExpression(operator, left, right)
+		return evalIntegerInfixExpression(operator, left, right, ctx)
+	case isNumeric(left) && isNumeric(right):
+		// mixed integer and float operands are promoted to float
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
+		return evalStringInfixExpression(operator, left, right, ctx)
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case operator == "!=":
@@ -183,23 +313,112 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	}
 }
 
-func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
+// evalLogicalExpression short-circuits && and ||, the result is always a boolean
+func evalLogicalExpression(node *ast.InfixExpression, ctx *object.Context) object.Object {
+	left := eval(node.Left, ctx)
+	if isAbrupt(left) {
+		return left
+	}
+	if isTruthy(left) == (node.Operator == "||") {
+		return nativeBoolToBooleanObject(isTruthy(left))
+	}
+	right := eval(node.Right, ctx)
+	if isAbrupt(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
+func evalIntegerInfixExpression(operator string, left, right object.Object, ctx *object.Context) object.Object {
 	leftVal := left.(*object.Integer).Value
 	rightVal := right.(*object.Integer).Value
 
+	switch operator {
+	case "+", "-", "*", "/", "%":
+		return evalIntegerArithmetic(operator, leftVal, rightVal, ctx.CheckedArithmetic)
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<", ">>":
+		if rightVal < 0 {
+			return newError("negative shift count: %d", rightVal)
+		}
+		if operator == "<<" {
+			return evalIntegerArithmetic(operator, leftVal, rightVal, ctx.CheckedArithmetic)
+		}
+		return &object.Integer{Value: leftVal >> rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// evalIntegerArithmetic reports division by zero as an error, and integer overflow
+// as well if checked arithmetic is enabled
+func evalIntegerArithmetic(operator string, left, right int64, checked bool) object.Object {
+	if (operator == "/" || operator == "%") && right == 0 {
+		return newError("division by zero")
+	}
+
+	var result int64
+	ok := true
+	switch operator {
+	case "+":
+		result, ok = object.AddInt64(left, right)
+	case "-":
+		result, ok = object.SubInt64(left, right)
+	case "*":
+		result, ok = object.MulInt64(left, right)
+	case "/":
+		result, ok = object.DivInt64(left, right)
+	case "%":
+		result = left % right
+	case "<<":
+		result, ok = object.ShlInt64(left, right)
+	}
+	if checked && !ok {
+		return newError("integer overflow: %d %s %d", left, operator, right)
+	}
+	return &object.Integer{Value: result}
+}
+
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		return &object.Float{Value: leftVal + rightVal}
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		return &object.Float{Value: leftVal - rightVal}
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		return &object.Float{Value: leftVal / rightVal}
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		return &object.Float{Value: leftVal * rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -209,30 +428,81 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	}
 }
 
-func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
+func isNumeric(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	}
+	return 0
+}
+
+func evalStringInfixExpression(operator string, left, right object.Object, ctx *object.Context) object.Object {
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
 
 	switch operator {
 	case "+":
+		allocate(object.StringSize(len(leftVal)+len(rightVal)), ctx)
 		return &object.String{Value: leftVal + rightVal}
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
+func evalInterpolatedString(node *ast.InterpolatedString, ctx *object.Context) object.Object {
+	allocate(object.StringSize(0), ctx)
+	var out strings.Builder
+	for _, part := range node.Parts {
+		value := eval(part, ctx)
+		if isAbrupt(value) {
+			return value
+		}
+		text := value.Inspect()
+		allocate(int64(len(text)), ctx)
+		out.WriteString(text)
+	}
+	return &object.String{Value: out.String()}
+}
+
 func evalIfExpression(ie *ast.IfExpression, ctx *object.Context) object.Object {
-	condition := Eval(ie.Condition, ctx)
-	if isError(condition) {
+	condition := eval(ie.Condition, ctx)
+	if isAbrupt(condition) {
 		return condition
 	} else if isTruthy(condition) {
-		return Eval(ie.Consequence, ctx)
+		return eval(ie.Consequence, ctx)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, ctx)
+		return eval(ie.Alternative, ctx)
 	}
 	return NULL
 }
 
+func evalTryExpression(te *ast.TryExpression, ctx *object.Context) object.Object {
+	result := eval(te.Block, ctx)
+	if err, ok := result.(*object.Error); ok {
+		handlerCtx := object.NewHandlerContext(ctx, te.Parameter.Value, err.Caught(callStack(err, ctx)))
+		result = eval(te.Handler, handlerCtx)
+	}
+	if result == nil {
+		return NULL // empty blocks
+	}
+	return result
+}
+
+// callStack completes the stack recorded while err was propagated up to ctx with
+// the frame of ctx itself
+func callStack(err *object.Error, ctx *object.Context) []object.StackFrame {
+	if len(err.Stack) == 0 {
+		return []object.StackFrame{{Function: ctx.FunctionName(), Pos: err.Pos}}
+	}
+	return err.Stack
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj {
 	case NULL:
@@ -247,21 +517,94 @@ func isTruthy(obj object.Object) bool {
 func evalBlockStatement(block *ast.BlockStatement, ctx *object.Context) object.Object {
 	var result object.Object
 	for _, statement := range block.Statements {
-		result = Eval(statement, ctx)
-		if result != nil && (result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ) {
+		result = eval(statement, ctx)
+		if isAbrupt(result) {
 			return result
 		}
 	}
 	return result
 }
 
+func evalWhileStatement(ws *ast.WhileStatement, ctx *object.Context) object.Object {
+	for {
+		condition := eval(ws.Condition, ctx)
+		if isAbrupt(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		result := eval(ws.Body, ctx)
+		if result == BREAK {
+			return NULL
+		}
+		if isLoopExit(result) {
+			return result
+		}
+	}
+}
+
+func evalForStatement(fs *ast.ForStatement, ctx *object.Context) object.Object {
+	iterable := eval(fs.Iterable, ctx)
+	if isAbrupt(iterable) {
+		return iterable
+	}
+	allocate(object.IterationSize(iterable), ctx)
+	elements, ok := object.IterableElements(iterable)
+	if !ok {
+		return newError("cannot iterate over %s", iterable.Type())
+	}
+
+	for _, element := range elements {
+		ctx.Set(fs.Variable.Value, element)
+
+		result := eval(fs.Body, ctx)
+		if result == BREAK {
+			return NULL
+		}
+		if isLoopExit(result) {
+			return result
+		}
+	}
+	return NULL
+}
+
+// isLoopExit reports whether a loop body result ends the enclosing loop's evaluation
+func isLoopExit(result object.Object) bool {
+	if result == nil {
+		return false
+	}
+	return result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ
+}
+
+// allocated counts the memory of a string, array or hash just created by a builtin against
+// the budget of the run, other values are returned unchanged as well. The evaluator counts the
+// values it creates itself with allocate before creating them.
+func allocated(obj object.Object, ctx *object.Context) object.Object {
+	allocate(object.SizeOf(obj), ctx)
+	return obj
+}
+
+// allocate aborts the evaluation like eval does once the memory budget is exceeded
+func allocate(bytes int64, ctx *object.Context) {
+	if err := ctx.Meter().Alloc(bytes); err != nil {
+		panic(err)
+	}
+}
+
 func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
-func isError(obj object.Object) bool {
+// isAbrupt reports whether obj is an error, a return value, break or continue, which end
+// the evaluation of every enclosing expression up to the statement handling them
+func isAbrupt(obj object.Object) bool {
 	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
+		switch obj.Type() {
+		case object.ERROR_OBJ, object.RETURN_VALUE_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+			return true
+		}
 	}
 	return false
 }
@@ -271,17 +614,17 @@ func evalIdentifier(node *ast.Identifier, ctx *object.Context) object.Object {
 		return value
 	}
 	// look up built-in functions
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin := object.GetBuiltinByName(node.Value); builtin != nil {
 		return builtin
 	}
-	return newError("identifier not found: " + node.Value)
+	return newError("identifier not found: %s", node.Value)
 }
 
 func evalExpressions(exps []ast.Expression, ctx *object.Context) []object.Object {
 	var result []object.Object
 	for _, e := range exps {
-		evaluated := Eval(e, ctx)
-		if isError(evaluated) {
+		evaluated := eval(e, ctx)
+		if isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -289,17 +632,37 @@ func evalExpressions(exps []ast.Expression, ctx *object.Context) []object.Object
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// applyFunction calls fn from ctx, builtins run with the settings of ctx
+func applyFunction(fn object.Object, args []object.Object, ctx *object.Context) object.Object {
 	function, ok := fn.(*object.Function)
 	if ok {
+		meter := function.Ctx.Meter()
+		if err := meter.Enter(); err != nil {
+			panic(err)
+		}
+		defer meter.Leave()
+		defer unwindFrame(function.Name)
+
+		if len(args) != len(function.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(function.Parameters), len(args))
+		}
 		extendedCtx := extendedFunctionCtx(function, args)
-		evaluated := Eval(function.Body, extendedCtx)
+		evaluated := eval(function.Body, extendedCtx)
+		if err, ok := evaluated.(*object.Error); ok && len(err.Stack) == 0 {
+			err.Stack = []object.StackFrame{{Function: extendedCtx.FunctionName(), Pos: err.Pos}}
+		}
 		return unwrapReturnValue(evaluated)
 	}
 	builtin, ok := fn.(*object.Builtin)
 	if ok {
+		if builtin.ResultSize != nil {
+			allocate(builtin.ResultSize(args...), ctx)
+		}
 		// no need to unwrap since builtins don't return the custom *object.ReturnValue type
-		return builtin.Fn(args...)
+		if result := builtin.Call(ctx.Sandbox, args...); result != nil {
+			return result
+		}
+		return NULL
 	}
 	return newError("Not a function: %s", fn.Type())
 }
@@ -318,26 +681,123 @@ func unwrapReturnValue(obj object.Object) object.Object {
 
 func extendedFunctionCtx(fn *object.Function, args []object.Object) *object.Context {
 	ctx := object.NewEnclosedContext(fn.Ctx)
+	ctx.Function = object.FunctionName(fn.Name)
 	for paramIdx, param := range fn.Parameters {
 		ctx.Set(param.Value, args[paramIdx])
 	}
 	return ctx
 }
 
+// evalAssignExpression evaluates the target of the assignment, its index and the current value
+// of a compound assignment before the assigned value, in the order the VM does
+func evalAssignExpression(node *ast.AssignExpression, ctx *object.Context) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		var current object.Object
+		if node.Operator != "=" {
+			current = evalIdentifier(target, ctx)
+			if isAbrupt(current) {
+				return current
+			}
+		}
+		value := evalAssignedValue(node, current, ctx)
+		if isAbrupt(value) {
+			return value
+		}
+		if _, ok := ctx.Assign(target.Value, value); !ok {
+			return newError("assignment to undeclared variable: %s", target.Value)
+		}
+		return value
+	case *ast.IndexExpression:
+		left := eval(target.Left, ctx)
+		if isAbrupt(left) {
+			return left
+		}
+		index := eval(target.Index, ctx)
+		if isAbrupt(index) {
+			return index
+		}
+		var current object.Object
+		if node.Operator != "=" {
+			switch left.Type() {
+			case object.ARRAY_OBJ:
+				current = evalArrayIndexExpression(left, index)
+			case object.HASH_OBJ:
+				current = evalHashIndexExpression(left, index)
+			default:
+				return newError("index operator not supported: %s", left.Type())
+			}
+			if isAbrupt(current) {
+				return current
+			}
+		}
+		value := evalAssignedValue(node, current, ctx)
+		if isAbrupt(value) {
+			return value
+		}
+		return evalIndexAssignment(left, index, value, ctx)
+	default:
+		return newError("cannot assign to %s", node.Target.String())
+	}
+}
+
+// evalAssignedValue evaluates the value of an assignment, combined with the current value
+// of the target for a compound assignment
+func evalAssignedValue(node *ast.AssignExpression, current object.Object, ctx *object.Context) object.Object {
+	value := eval(node.Value, ctx)
+	if isAbrupt(value) || node.Operator == "=" {
+		return value
+	}
+	return evalInfixExpression(compoundOperator(node.Operator), current, value, ctx)
+}
+
+// compoundOperator maps a compound assignment like "+=" to its infix operator
+func compoundOperator(operator string) string {
+	return strings.TrimSuffix(operator, "=")
+}
+
+func evalIndexAssignment(left, index, value object.Object, ctx *object.Context) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		indexNr, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		if indexNr.Value < 0 || indexNr.Value >= int64(len(left.Elements)) {
+			return newError("array index out of range: %d", indexNr.Value)
+		}
+		left.Elements[indexNr.Value] = value
+		return value
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		if _, ok := left.Pairs[key.HashKey()]; !ok {
+			allocate(object.HashPairSize, ctx)
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+		return value
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+}
+
 func evalHashLiteral(node *ast.HashLiteral, ctx *object.Context) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	allocate(object.HashSize(len(node.Pairs)), ctx)
+	pairs := make(map[object.HashKey]object.HashPair, len(node.Pairs))
 
 	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, ctx)
-		if isError(key) {
+		key := eval(keyNode, ctx)
+		if isAbrupt(key) {
 			return key
 		}
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
 			return newError("Unusable as a hash key: %s", key.Type())
 		}
-		value := Eval(valueNode, ctx)
-		if isError(value) {
+		value := eval(valueNode, ctx)
+		if isAbrupt(value) {
 			return value
 		}
 		hashed := hashKey.HashKey()
@@ -360,6 +820,16 @@ func evalArrayIndexExpression(left object.Object, index object.Object) object.Ob
 	return arr.Elements[indexNr.Value]
 }
 
+// evalStringIndexExpression indexes strings by code point, not by byte
+func evalStringIndexExpression(str, index object.Object, ctx *object.Context) object.Object {
+	r, ok := object.RuneAt(str.(*object.String).Value, index.(*object.Integer).Value)
+	if !ok {
+		return NULL
+	}
+	allocate(object.StringSize(utf8.RuneLen(r)), ctx)
+	return &object.String{Value: string(r)}
+}
+
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
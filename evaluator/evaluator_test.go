@@ -1,10 +1,18 @@
 package evaluator
 
 import (
+	"context"
+	"fmt"
+	"math"
+	"monkey-int/ast"
 	"monkey-int/lexer"
 	"monkey-int/object"
 	"monkey-int/parser"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -210,6 +218,10 @@ func TestErrorHandling(t *testing.T) {
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: MONKEY_FUNC",
 		},
+		{
+			"let add = fn(a, b) { a + b }; add(1);",
+			"wrong number of arguments: want=2, got=1",
+		},
 	}
 
 	for _, tt := range tests {
@@ -310,6 +322,35 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+func TestStringIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"héllo"[1]`, "é"},
+		{`"😀!"[1]`, "!"},
+		{`"abc"[3]`, nil},
+		{`"abc"[-1]`, nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		expected, ok := tt.expected.(string)
+		if !ok {
+			testNullObject(t, evaluated)
+			continue
+		}
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("object is not a String. Got=%T (%+v) instead.", evaluated, evaluated)
+			continue
+		}
+		if str.Value != expected {
+			t.Errorf("String has wrong value. Wanted=%q, got=%q instead.", expected, str.Value)
+		}
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -318,6 +359,7 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
+		{`len("héllo")`, 5},
 		{`len(1)`, "argument to `len` not supported, got MONKEY_INT"},
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
 		{`len([1, 2, 3])`, 3},
@@ -512,6 +554,18 @@ func TestHashIndexExpressions(t *testing.T) {
 			`{false: 5}[false]`,
 			5,
 		},
+		{
+			`{1.0: 5}[1]`,
+			5,
+		},
+		{
+			`{1: 5}[1.0]`,
+			5,
+		},
+		{
+			`{1.5: 5}[1]`,
+			nil,
+		},
 	}
 
 	for _, tt := range tests {
@@ -524,3 +578,511 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = 1;\nlet b = a + \"x\";", "2:11: type mismatch: MONKEY_INT + MONKEY_STRING"},
+		{"let f = fn() {\n  -true\n};\nf();", "2:3: unknown operator: -MONKEY_BOOL"},
+		{"\n\nfoobar", "3:1: identifier not found: foobar"},
+		{"len(1)", "1:4: argument to `len` not supported, got MONKEY_INT"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errorObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("No error object returned. Got=%T(%+v) instead.", evaluated, evaluated)
+			continue
+		}
+		if errorObj.Describe() != tt.expected {
+			t.Errorf("Wrong error. Expected=%q, got=%q instead.", tt.expected, errorObj.Describe())
+		}
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"1 / 4.0", 0.25},
+		{"10 - 0.5", 9.5},
+		{"1e2 + 1", 101.0},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"1.5 != 1.5", false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not a Float. Got=%T (%+v) instead.", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. Got=%g, wanted=%g", result.Value, expected)
+		return false
+	}
+	return true
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"while (false) { 1 }; 3", 3},
+		{"let f = fn() { while (true) { return 5; } }; f()", 5},
+		{"let f = fn() { while (true) { break; } 7 }; f()", 7},
+		{"let f = fn() { for (x in [1, 2, 3, 4]) { if (x == 3) { return x; } } }; f()", 3},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x < 2) { continue; } return x; } }; f()", 2},
+		{"let f = fn() { for (x in []) { return 1; } 2 }; f()", 2},
+		{"let f = fn() { for (k in {2: 1, 1: 2}) { return k; } }; f()", 1},
+		{`for (c in "abc") { }; c`, "c"},
+		{"let f = fn() { for (x in [1, 2]) { for (y in [3, 4]) { break; } return x + 10; } }; f()", 11},
+		{"for (x in 1) { }", "cannot iterate over MONKEY_INT"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if errorObj, ok := evaluated.(*object.Error); ok {
+				if errorObj.Message != expected {
+					t.Errorf("Wrong error message. Expected=%q, got=%q instead.", expected, errorObj.Message)
+				}
+				continue
+			}
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not a String. Got=%T (%+v) instead.", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. Wanted=%q, got=%q instead.", expected, str.Value)
+			}
+		}
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = 1; a = 2; a", 2},
+		{"let a = 1; a += 2; a *= 4; a -= 2; a /= 5; a", 2},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		{"let a = 1; a = 5", 5},
+		{"let s = 0; for (x in [1, 2, 3]) { s += x }; s", 6},
+		{"let i = 0; while (i < 10) { i += 1 }; i", 10},
+		{"let a = 1; let f = fn() { a = 7 }; f(); a", 7},
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()", 3},
+		{"let mk = fn() { let c = 0; let inc = fn() { c += 1; c }; inc(); inc(); c }; mk()", 2},
+		{"let arr = [1, 2, 3]; arr[2] *= 3; arr[2]", 9},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] += 10; h["a"] + h["b"]`, 13},
+		{"b = 1", "assignment to undeclared variable: b"},
+		{"let arr = [1]; arr[3] = 1", "array index out of range: 3"},
+		{"let a = 1; a[0] = 1", "index assignment not supported: MONKEY_INT"},
+		{`let a = 1; a += "x"`, "type mismatch: MONKEY_INT + MONKEY_STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errorObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("No error object returned. Got=%T (%+v) instead.", evaluated, evaluated)
+				continue
+			}
+			if errorObj.Message != expected {
+				t.Errorf("Wrong error message. Expected=%q, got=%q instead.", expected, errorObj.Message)
+			}
+		}
+	}
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let name = "Ann"; let age = 41; "hello ${name}, you are ${age + 1}"`, "hello Ann, you are 42"},
+		{`"${1.5} ${true} ${[1, "a"]} ${if (false) { 1 }}"`, "1.5 true [1, a] null"},
+		{`"a ${"b ${1 + 1}"} c"`, "a b 2 c"},
+		{`"\${not interpolated}"`, "${not interpolated}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("object is not a String. Got=%T (%+v) instead.", evaluated, evaluated)
+			continue
+		}
+		if str.Value != tt.expected {
+			t.Errorf("String has wrong value. Wanted=%q, got=%q instead.", tt.expected, str.Value)
+		}
+	}
+
+	evaluated := testEval(`"${-true}"`)
+	if _, ok := evaluated.(*object.Error); !ok {
+		t.Errorf("Expected an error for an invalid interpolated expression, got=%T (%+v)", evaluated, evaluated)
+	}
+}
+
+func TestOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"1 << 4", 16},
+		{"-16 >> 2", -4},
+		{"1 << -1", "negative shift count: -1"},
+		{"~1.5", "unknown operator: ~MONKEY_FLOAT"},
+		{"1.5 & 1", "unknown operator: MONKEY_FLOAT & MONKEY_INT"},
+		{"7.5 % 2", 1.5},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"2 >= 2", true},
+		{"1.5 >= 2", false},
+		{"true && false", false},
+		{"true && 1", true},
+		{"false || 0", true},
+		{"false || false", false},
+		{"if (false) { 1 } && x", false},
+		{"true || x", true},
+		{"false && x", false},
+		{"true && x", "identifier not found: x"},
+		{"let n = 0; let inc = fn() { n += 1; true }; false && inc(); true || inc(); n", 0},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errorObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("No error object returned. Got=%T (%+v) instead.", evaluated, evaluated)
+				continue
+			}
+			if errorObj.Message != expected {
+				t.Errorf("Wrong error message. Expected=%q, got=%q instead.", expected, errorObj.Message)
+			}
+		}
+	}
+}
+
+func TestArithmeticErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		checked  bool
+		expected interface{}
+	}{
+		{"1 / 0", false, "division by zero"},
+		{"1 % 0", false, "division by zero"},
+		{"let a = 5; a /= 0", false, "division by zero"},
+		{"1.0 / 0", false, math.Inf(1)},
+		{"9223372036854775807 + 1", false, math.MinInt64},
+		{"9223372036854775807 + 1", true, "integer overflow: 9223372036854775807 + 1"},
+		{"-9223372036854775807 - 2", true, "integer overflow: -9223372036854775807 - 2"},
+		{"4611686018427387904 * 2", true, "integer overflow: 4611686018427387904 * 2"},
+		{"-(-9223372036854775807 - 1)", true, "integer overflow: --9223372036854775808"},
+		{"(-9223372036854775807 - 1) / -1", true, "integer overflow: -9223372036854775808 / -1"},
+		{"1 << 63", true, "integer overflow: 1 << 63"},
+		{"let f = fn(x) { x * x }; f(3037000500)", true, "integer overflow: 3037000500 * 3037000500"},
+		{"9223372036854775806 + 1", true, math.MaxInt64},
+		{"-4611686018427387904 * 2", true, math.MinInt64},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		ctx := object.NewContext()
+		ctx.CheckedArithmetic = tt.checked
+		evaluated := Eval(p.ParseProgram(), ctx)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case string:
+			errorObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%s: no error object returned. Got=%T (%+v) instead.", tt.input, evaluated, evaluated)
+				continue
+			}
+			if errorObj.Message != expected {
+				t.Errorf("Wrong error message. Expected=%q, got=%q instead.", expected, errorObj.Message)
+			}
+		}
+	}
+}
+
+func TestInternalErrors(t *testing.T) {
+	input := `let f = fn() {
+  -1
+};
+let g = fn() { f() };
+g();`
+
+	program := parser.New(lexer.New(input)).ParseProgram()
+	// break the AST the way a buggy interpreter change might: the operand of -1 goes missing
+	fnLiteral := program.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	prefix := fnLiteral.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.PrefixExpression)
+	prefix.Right = nil
+
+	evaluated := Eval(program, object.NewContext())
+	errorObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("No error object returned. Got=%T (%+v) instead.", evaluated, evaluated)
+	}
+	if !strings.HasPrefix(errorObj.Describe(), "2:3: internal error: runtime error:") {
+		t.Errorf("Wrong error message. Got=%q", errorObj.Describe())
+	}
+
+	expectedStack := "\tat f (2:3)\n\tat g (4:17)\n\tat <main> (5:2)\n"
+	if stack := object.FormatStack(errorObj.Stack); stack != expectedStack {
+		t.Errorf("Wrong stack. Wanted=%q, got=%q instead.", expectedStack, stack)
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { } catch (e) { 2 }`, nil},
+		{`try { 1 / 0 } catch (e) { e["message"] }`, "division by zero"},
+		{`try { 1 / 0 } catch (e) { e["kind"] }`, "RuntimeError"},
+		{`try { len(1) } catch (e) { e["message"] }`, "argument to `len` not supported, got MONKEY_INT"},
+		{`try { throw("boom") } catch (e) { e["message"] + " " + e["kind"] }`, "boom Error"},
+		{`try { throw("bad", "ValueError") } catch (e) { e["kind"] }`, "ValueError"},
+		{`try { throw([1, 2]) } catch (e) { e["message"] }`, "[1, 2]"},
+		{`try { throw("x") } catch (e) { "${e}" }`, "Error: x"},
+		{`try { throw("x") } catch (e) { e["other"] }`, nil},
+		{`try { try { 1 / 0 } catch (e) { throw(e) } } catch (e) { e["message"] }`, "division by zero"},
+		{`1 + try { 2 + [3, 4 / 0][0] } catch (e) { 10 }`, 11},
+		{`let f = fn(a) { let b = 2; a + try { b * throw("x") } catch (e) { b } }; f(1)`, 3},
+		{`let n = 0; for (x in [1, 0, 2]) { n += try { 10 / x } catch (e) { 100 } }; n`, 115},
+		{`let f = fn() { try { return 1 } catch (e) { 2 }; 3 }; f()`, 1},
+		{`let n = 0; while (true) { try { n += 1; if (n == 3) { break } } catch (e) { } }; n`, 3},
+		{`let f = fn(x) { if (x == 0) { throw("done") } f(x - 1) }; try { f(10) } catch (e) { len(e["stack"]) }`, 12},
+		{"let f = fn() { throw(\"x\") };\ntry { f() } catch (e) { e[\"stack\"] }", []string{"at f (1:21)", "at <main> (2:8)"}},
+		{"let f = fn() { 1 / 0 };\nlet g = fn() { try { f() } catch (e) { e[\"stack\"] } };\ng()", []string{"at f (1:18)", "at g (2:23)"}},
+		{`try { throw("a") } catch (e) { throw("b") }`, &object.Error{Message: "b"}},
+		{`throw("c", 1)`, &object.Error{Message: "error kind must be MONKEY_STRING, got MONKEY_INT"}},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("%s: object is not a String. Got=%T (%+v) instead.", tt.input, evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. Wanted=%q, got=%q instead.", expected, str.Value)
+			}
+		case []string:
+			array, ok := evaluated.(*object.Array)
+			if !ok {
+				t.Errorf("%s: object is not an Array. Got=%T (%+v) instead.", tt.input, evaluated, evaluated)
+				continue
+			}
+			if array.Inspect() != "["+strings.Join(expected, ", ")+"]" {
+				t.Errorf("Wrong stack. Wanted=%q, got=%s instead.", expected, array.Inspect())
+			}
+		case *object.Error:
+			errorObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("No error object returned. Got=%T (%+v) instead.", evaluated, evaluated)
+				continue
+			}
+			if errorObj.Message != expected.Message {
+				t.Errorf("Wrong error message. Expected=%q, got=%q instead.", expected.Message, errorObj.Message)
+			}
+		}
+	}
+}
+
+// writeModules writes the module files used by the import tests to a new directory
+func writeModules(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"lib.mk":    "import \"util.mk\" as u\nlet count = 0;\nlet inc = fn() { count += 1; count };\nlet twice = fn(x) { u[\"double\"](x) };",
+		"util.mk":   "let double = fn(x) { x * 2 };",
+		"early.mk":  "let a = 1;\nif (a == 1) { return 0 }\nlet b = 2;",
+		"cycle1.mk": `import "cycle2.mk" as c`,
+		"cycle2.mk": `import "cycle1.mk" as c`,
+		"bad.mk":    "let f = fn() { 1 / 0 };\nf();",
+		"syntax.mk": "let = 1;",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatalf("could not write module: %s", err)
+		}
+	}
+	return dir
+}
+
+func TestImports(t *testing.T) {
+	dir := writeModules(t)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`import "lib.mk" as l; l["twice"](21)`, 42},
+		{`import "lib.mk" as a; import "lib.mk" as b; a["inc"](); b["inc"]()`, 2},
+		{`import "lib.mk" as a; import "lib.mk" as b; if (a == b) { 1 } else { 0 }`, 1},
+		{`import "lib.mk" as l; l["count"]`, 0},
+		{`import "util.mk" as u; let double = 5; u["double"](double)`, 10},
+		{`import "early.mk" as e; e["a"]`, 1},
+		{`import "early.mk" as e; e["b"]`, `module early.mk has no member "b"`},
+		{`import "lib.mk" as l; l[1]`, "module member must be MONKEY_STRING, got MONKEY_INT"},
+		{`import "cycle1.mk" as c`, "import cycle: " + filepath.Join(dir, "cycle1.mk") + " -> " + filepath.Join(dir, "cycle2.mk") + " -> " + filepath.Join(dir, "cycle1.mk")},
+		{`import "syntax.mk" as s`, `could not import "syntax.mk": ` + filepath.Join(dir, "syntax.mk") + `:1:5: expected identifier, found "="`},
+		{`import "missing.mk" as m`, `could not import "missing.mk"`},
+		{`import "bad.mk" as b`, []string{"at f (" + filepath.Join(dir, "bad.mk") + ":1:18)", "at <module bad.mk> (" + filepath.Join(dir, "bad.mk") + ":2:2)", "at <main> (" + filepath.Join(dir, "main.mk") + ":1:1)"}},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.NewWithFile(filepath.Join(dir, "main.mk"), tt.input))
+		evaluated := Eval(p.ParseProgram(), object.NewContext())
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errorObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%s: no error object returned. Got=%T (%+v) instead.", tt.input, evaluated, evaluated)
+				continue
+			}
+			if !strings.HasPrefix(errorObj.Message, expected) {
+				t.Errorf("Wrong error message. Expected=%q, got=%q instead.", expected, errorObj.Message)
+			}
+		case []string:
+			errorObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%s: no error object returned. Got=%T (%+v) instead.", tt.input, evaluated, evaluated)
+				continue
+			}
+			if stack := object.FormatStack(errorObj.Stack); stack != "\t"+strings.Join(expected, "\n\t")+"\n" {
+				t.Errorf("Wrong stack. Wanted=%q, got=%q instead.", expected, stack)
+			}
+		}
+	}
+}
+
+func TestBudgets(t *testing.T) {
+	recursion := "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } };"
+	// doubled defines s as a string of 2^(n+1) characters
+	doubled := func(n int) string {
+		return fmt.Sprintf(`let s = "ab"; let i = 0; while (i < %d) { s = s + s; i = i + 1 };`, n)
+	}
+	locals := "let g = fn(n) { let a = 1; let b = 2; let c = 3; if (n == 0) { a + b + c } else { g(n - 1) } };"
+	tests := []struct {
+		input     string
+		budget    object.Budget
+		cancelled bool
+		expected  string // error message, empty if the run succeeds
+	}{
+		{"while (true) { }", object.Budget{MaxSteps: 1000}, false, "budget exceeded: more than 1000 steps"},
+		{"let f = fn() { f() }; f()", object.Budget{}, false, "budget exceeded: call depth exceeds 1000"},
+		{recursion + "f(50)", object.Budget{MaxCallDepth: 10}, false, "budget exceeded: call depth exceeds 10"},
+		{recursion + "f(5)", object.Budget{MaxCallDepth: 10}, false, ""},
+		{recursion + "f(2000)", object.Budget{MaxCallDepth: 5000}, false, ""},
+		{locals + "g(998)", object.Budget{}, false, ""},
+		{locals + "g(1000)", object.Budget{}, false, "budget exceeded: call depth exceeds 1000"},
+		{"try { while (true) { } } catch (e) { 1 }", object.Budget{MaxSteps: 1000}, false, "budget exceeded: more than 1000 steps"},
+		{"while (true) { }", object.Budget{Timeout: 10 * time.Millisecond}, false, "budget exceeded: time limit of 10ms"},
+		{"1", object.Budget{}, true, "budget exceeded: context canceled"},
+		{`let s = "ab"; while (true) { s = s + s }`, object.Budget{MaxMemory: 1 << 20}, false, "budget exceeded: more than 1048576 bytes allocated"},
+		{`let s = "ab"; while (true) { s = "${s}${s}" }`, object.Budget{MaxMemory: 1 << 20}, false, "budget exceeded: more than 1048576 bytes allocated"},
+		{"let a = []; while (true) { a = push(a, 1) }", object.Budget{MaxMemory: 100000}, false, "budget exceeded: more than 100000 bytes allocated"},
+		{"let h = {}; let i = 0; while (true) { h[i] = i; i = i + 1 }", object.Budget{MaxMemory: 100000}, false, "budget exceeded: more than 100000 bytes allocated"},
+		{"while (true) { [1, 2, 3] }", object.Budget{MaxMemory: 100000}, false, "budget exceeded: more than 100000 bytes allocated"},
+		{`try { let s = "ab"; while (true) { s = s + s } } catch (e) { 1 }`, object.Budget{MaxMemory: 1 << 20}, false, "budget exceeded: more than 1048576 bytes allocated"},
+		{`let a = [1, 2, 3]; let h = {"a": a}; h["b"] = "c" + "d"`, object.Budget{MaxMemory: 1000}, false, ""},
+		// values returned by builtins are only counted if the builtin created them
+		{doubled(17) + "let a = [s]; first(a); first(a); first(a); last(a); last(a)", object.Budget{MaxMemory: 1 << 20}, false, ""},
+		{doubled(17) + "let a = [s]; tail(a); tail(a); len(s)", object.Budget{MaxMemory: 1 << 20}, false, ""},
+		{doubled(13) + "for (c in s) { }", object.Budget{MaxMemory: 100000}, false, "budget exceeded: more than 100000 bytes allocated"},
+	}
+
+	for _, tt := range tests {
+		goCtx, cancel := context.WithCancel(context.Background())
+		if tt.cancelled {
+			cancel()
+		}
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalWithBudget(goCtx, program, object.NewContext(), tt.budget)
+		cancel()
+
+		errorObj, ok := evaluated.(*object.Error)
+		if tt.expected == "" {
+			if ok {
+				t.Errorf("%q: unexpected error: %s", tt.input, errorObj.Describe())
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%q: no error object returned. Got=%T (%+v) instead.", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errorObj.Message != tt.expected {
+			t.Errorf("%q: wrong error message. Wanted=%q, got=%q", tt.input, tt.expected, errorObj.Message)
+		}
+		if errorObj.ErrorKind() != object.BudgetExceededKind {
+			t.Errorf("%q: wrong error kind. Got=%q", tt.input, errorObj.ErrorKind())
+		}
+	}
+}
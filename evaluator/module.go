@@ -0,0 +1,53 @@
+package evaluator
+
+import (
+	"monkey-int/ast"
+	"monkey-int/object"
+	"monkey-int/parser"
+)
+
+// evalImportStatement returns the module imported by is. The module file is evaluated in
+// its own global context the first time it is imported, later imports share the result.
+func evalImportStatement(is *ast.ImportStatement, ctx *object.Context) object.Object {
+	file := is.ModuleFile()
+	modules := ctx.Modules()
+	if module, ok := modules.Get(file); ok {
+		return module
+	}
+
+	if err := modules.Begin(is.Pos().File, file); err != nil {
+		return newError("%s", err)
+	}
+	var module *object.Module
+	defer func() { modules.End(module) }()
+
+	// modules are read through the sandbox like the files of readfile
+	src, err := ctx.Sandbox.ReadFile(file)
+	if err != nil {
+		return newError("could not import %q: %s", is.Path, err)
+	}
+	program, err := parser.ParseFile(file, src)
+	if err != nil {
+		return newError("could not import %q: %s", is.Path, err)
+	}
+
+	name := object.ModuleFunctionName(is.Path)
+	defer unwindFrame(name)
+
+	moduleCtx := ctx.NewModuleContext(name)
+	result := eval(program, moduleCtx)
+	if err, ok := result.(*object.Error); ok {
+		// the error was raised within the module, record the import as its call site
+		err.Stack = append(callStack(err, moduleCtx), object.StackFrame{Function: ctx.FunctionName(), Pos: is.Pos()})
+		return err
+	}
+
+	// a return at the top level ends the module early, lets that were not reached are left out
+	module = &object.Module{Name: is.Path, Exports: map[string]object.Object{}}
+	for _, export := range program.Exports() {
+		if value, ok := moduleCtx.Get(export); ok {
+			module.Exports[export] = value
+		}
+	}
+	return module
+}
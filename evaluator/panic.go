@@ -0,0 +1,79 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"monkey-int/ast"
+	"monkey-int/object"
+	"monkey-int/token"
+)
+
+// evalPanic carries a Go panic up through the evaluator while the Monkey call stack is recorded
+type evalPanic struct {
+	value interface{}
+	pos   token.Position // innermost position known within the function being unwound
+	stack []object.StackFrame
+}
+
+func asEvalPanic(r interface{}) *evalPanic {
+	if p, ok := r.(*evalPanic); ok {
+		return p
+	}
+	return &evalPanic{value: r}
+}
+
+// annotatePanic is deferred for every evaluated node, so the innermost node with
+// a valid position becomes the position of the panic
+func annotatePanic(node ast.Node) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	p := asEvalPanic(r)
+	if !p.pos.IsValid() {
+		p.pos = nodePos(node)
+	}
+	panic(p)
+}
+
+// unwindFrame is deferred for every function call and records the function on the stack
+func unwindFrame(name string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	p := asEvalPanic(r)
+	p.stack = append(p.stack, object.StackFrame{Function: object.FunctionName(name), Pos: p.pos})
+	p.pos = token.Position{} // continue with the call site in the caller
+	panic(p)
+}
+
+// internalError turns a recovered panic into an error. Exceeding the budget panics as well,
+// so the evaluation is aborted past any try expression.
+func internalError(r interface{}) *object.Error {
+	p := asEvalPanic(r)
+	stack := append(p.stack, object.StackFrame{Function: "<main>", Pos: p.pos})
+	err := &object.Error{
+		Message: fmt.Sprintf("internal error: %v", p.value),
+		Kind:    object.InternalErrorKind,
+		Pos:     stack[0].Pos,
+		Stack:   stack,
+	}
+	if budgetErr, ok := p.value.(error); ok && errors.Is(budgetErr, object.ErrBudgetExceeded) {
+		err.Message, err.Kind = budgetErr.Error(), object.BudgetExceededKind
+	}
+	return err
+}
+
+// nodePos returns the position of node, or no position for malformed (nil) nodes
+func nodePos(node ast.Node) (pos token.Position) {
+	defer func() {
+		if recover() != nil {
+			pos = token.Position{}
+		}
+	}()
+	if node == nil {
+		return token.Position{}
+	}
+	return node.Pos()
+}
@@ -1,3 +1,3 @@
 module monkey-int
 
-go 1.21.5
+go 1.25
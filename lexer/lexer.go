@@ -1,44 +1,124 @@
 package lexer
 
-import "monkey-int/token"
+import (
+	"fmt"
+	"monkey-int/token"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 type Lexer struct {
 	input        string
 	position     int
 	readPosition int
-	ch           byte
+	ch           rune // current character, decoded from UTF-8
+
+	file   string
+	line   int // line of ch
+	column int // column of ch
+
+	errors []Error
+
+	// brace depth of each ${...} interpolation currently open, innermost last
+	templates []int
 }
 
 func New(input string) *Lexer {
-	l := Lexer{input: input}
+	return NewWithFile("", input)
+}
+
+// NewWithFile creates a lexer whose token positions refer to the given file name
+func NewWithFile(file string, input string) *Lexer {
+	l := Lexer{input: input, file: file, line: 1}
 	l.readChar()
+	l.skipShebang()
 	return &l
 }
 
+// skipShebang ignores a leading "#!" interpreter line so scripts can be executed directly
+func (l *Lexer) skipShebang() {
+	if l.ch != '#' || l.peekChar() != '!' {
+		return
+	}
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+}
+
+// Error is a problem found while scanning, such as an unterminated comment
+type Error struct {
+	Pos token.Position
+	Msg string
+}
+
+func (e Error) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Msg
+	}
+	return e.Msg
+}
+
+// Errors returns the errors found while scanning so far
+func (l *Lexer) Errors() []Error {
+	return l.errors
+}
+
+func (l *Lexer) addError(pos token.Position, format string, a ...interface{}) {
+	l.errors = append(l.errors, Error{Pos: pos, Msg: fmt.Sprintf(format, a...)})
+}
+
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+
+	size := 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, size = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 	l.position = l.readPosition
-	l.readPosition++
+	l.readPosition += size
 }
 
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
 	} else {
-		return l.input[l.readPosition]
+		r, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+		return r
+	}
+}
+
+// peekCharAt looks n bytes ahead of the current character without consuming anything,
+// it is only meant for ASCII lookahead
+func (l *Lexer) peekCharAt(n int) rune {
+	pos := l.position + n
+	if pos >= len(l.input) {
+		return 0
 	}
+	return rune(l.input[pos])
 }
 
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
 	l.eatWhitespace()
+	pos := l.currentPosition()
 
 	switch l.ch {
+	case '/':
+		if l.peekChar() == '/' && l.atDocComment() {
+			tok = token.Token{Type: token.DOC_COMMENT, Literal: l.readDocComment()}
+			tok.Pos = pos
+			return tok
+		}
+		tok = l.newCompoundToken(token.SLASH, token.SLASH_ASSIGN)
 	case '=':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -66,39 +146,81 @@ func (l *Lexer) NextToken() token.Token {
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.newCompoundToken(token.PLUS, token.PLUS_ASSIGN)
 	case '{':
+		if len(l.templates) > 0 {
+			l.templates[len(l.templates)-1]++
+		}
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
+		if len(l.templates) > 0 && l.templates[len(l.templates)-1] == 0 {
+			// closes an interpolation, the string continues after it
+			l.templates = l.templates[:len(l.templates)-1]
+			tok = l.readStringToken(pos, token.TEMPLATE_END)
+			break
+		}
+		if len(l.templates) > 0 {
+			l.templates[len(l.templates)-1]--
+		}
 		tok = newToken(token.RBRACE, l.ch)
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
 		tok = newToken(token.RBRACKET, l.ch)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
-	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		tok = l.newCompoundToken(token.MINUS, token.MINUS_ASSIGN)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		tok = l.newCompoundToken(token.ASTERISK, token.ASTERISK_ASSIGN)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.newTwoCharToken(token.LT_EQ)
+		case '<':
+			tok = l.newTwoCharToken(token.SHIFT_LEFT)
+		default:
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.newTwoCharToken(token.GT_EQ)
+		case '>':
+			tok = l.newTwoCharToken(token.SHIFT_RIGHT)
+		default:
+			tok = newToken(token.GT, l.ch)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
+	case '&':
+		if l.peekChar() == '&' {
+			tok = l.newTwoCharToken(token.AND)
+		} else {
+			tok = newToken(token.BIT_AND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			tok = l.newTwoCharToken(token.OR)
+		} else {
+			tok = newToken(token.BIT_OR, l.ch)
+		}
+	case '^':
+		tok = newToken(token.BIT_XOR, l.ch)
+	case '~':
+		tok = newToken(token.BIT_NOT, l.ch)
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok = l.readStringToken(pos, token.STRING)
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = tok.LookupIdent(tok.Literal)
+			tok.Pos = pos
 			return tok // returning because readChar is already called in readIdentifier
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			tok.Type, tok.Literal = l.readNumber()
+			tok.Pos = pos
 			return tok // same reason to return early here
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
@@ -106,18 +228,41 @@ func (l *Lexer) NextToken() token.Token {
 	}
 
 	l.readChar()
+	tok.Pos = pos
 	return tok
 }
 
-func newToken(myTokenType token.TokenType, ch byte) token.Token {
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{File: l.file, Line: l.line, Column: l.column}
+}
+
+func newToken(myTokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: myTokenType, Literal: string(ch)}
 }
 
-func isLetter(ch byte) bool {
-	return ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || (ch == '_')
+// newTwoCharToken consumes the next character and returns a token made of both
+func (l *Lexer) newTwoCharToken(tokenType token.TokenType) token.Token {
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: tokenType, Literal: string(ch) + string(l.ch)}
+}
+
+// newCompoundToken returns the compound assignment token (e.g. +=) if the current
+// operator character is followed by '=', or the plain operator token otherwise
+func (l *Lexer) newCompoundToken(operator token.TokenType, compound token.TokenType) token.Token {
+	if l.peekChar() == '=' {
+		ch := l.ch
+		l.readChar()
+		return token.Token{Type: compound, Literal: string(ch) + string(l.ch)}
+	}
+	return newToken(operator, l.ch)
+}
+
+func isLetter(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '_'
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
@@ -129,27 +274,178 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer or a floating point literal such as 1.5, 2e10 or 3.0E-2
+func (l *Lexer) readNumber() (token.TokenType, string) {
+	var tokenType token.TokenType = token.INT
 	position := l.position
+	l.readDigits()
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if isDigit(next) || ((next == '+' || next == '-') && isDigit(l.peekCharAt(2))) {
+			tokenType = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+
+	return tokenType, l.input[position:l.position]
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
 }
 
+// eatWhitespace skips whitespace as well as // line and /* */ block comments,
+// doc comments (exactly three slashes) are left for NextToken
 func (l *Lexer) eatWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r':
+			l.readChar()
+		case l.ch == '/' && l.peekChar() == '/' && !l.atDocComment():
+			l.skipLineComment()
+		case l.ch == '/' && l.peekChar() == '*':
+			l.skipBlockComment()
+		default:
+			return
+		}
+	}
+}
+
+func (l *Lexer) atDocComment() bool {
+	return l.peekCharAt(2) == '/' && l.peekCharAt(3) != '/'
+}
+
+func (l *Lexer) skipLineComment() {
+	for l.ch != '\n' && l.ch != 0 {
 		l.readChar()
 	}
 }
 
-func (l *Lexer) readString() string {
-	position := l.position + 1
+// skipBlockComment skips a /* */ comment, block comments may be nested
+func (l *Lexer) skipBlockComment() {
+	pos := l.currentPosition()
+	depth := 0
 	for {
+		switch {
+		case l.ch == 0:
+			l.addError(pos, "unterminated block comment")
+			return
+		case l.ch == '/' && l.peekChar() == '*':
+			depth++
+			l.readChar()
+		case l.ch == '*' && l.peekChar() == '/':
+			depth--
+			l.readChar()
+			if depth == 0 {
+				l.readChar()
+				return
+			}
+		}
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+	}
+}
+
+// readDocComment reads a /// comment and returns its text without the slashes
+func (l *Lexer) readDocComment() string {
+	position := l.position + 3
+	l.skipLineComment()
+	return strings.TrimSpace(l.input[position:l.position])
+}
+
+// readStringToken reads the rest of a string, returning a token of type endType if the
+// string ends, or a TEMPLATE_PART if it is interrupted by an interpolation
+func (l *Lexer) readStringToken(pos token.Position, endType token.TokenType) token.Token {
+	literal, interpolation := l.readString(pos)
+	if interpolation {
+		l.templates = append(l.templates, 0)
+		return token.Token{Type: token.TEMPLATE_PART, Literal: literal}
+	}
+	return token.Token{Type: endType, Literal: literal}
+}
+
+// readString reads a string literal starting at pos and resolves its escape sequences.
+// It stops at the closing quote or at the start of a ${ interpolation, which is reported.
+func (l *Lexer) readString(pos token.Position) (string, bool) {
+	var out strings.Builder
+	for {
+		l.readChar()
+		switch l.ch {
+		case '"':
+			return out.String(), false
+		case 0:
+			if l.position >= len(l.input) {
+				l.addError(pos, "unterminated string")
+				return out.String(), false
+			}
+			out.WriteRune(l.ch)
+		case '$':
+			if l.peekChar() == '{' {
+				l.readChar()
+				return out.String(), true
+			}
+			out.WriteRune(l.ch)
+		case '\\':
+			l.readEscape(&out)
+		default:
+			out.WriteRune(l.ch)
 		}
 	}
-	return l.input[position:l.position]
+}
+
+var escapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'"':  '"',
+	'$':  '$',
+	'\\': '\\',
+}
+
+// readEscape reads the escape sequence following a backslash: \n, \t, \r, \", \$, \\ or \u{hex}
+func (l *Lexer) readEscape(out *strings.Builder) {
+	pos := l.currentPosition()
+	l.readChar()
+	if l.ch == 0 && l.position >= len(l.input) {
+		return // reported as an unterminated string
+	}
+	if r, ok := escapes[l.ch]; ok {
+		out.WriteRune(r)
+		return
+	}
+	if l.ch != 'u' || l.peekChar() != '{' {
+		l.addError(pos, "invalid escape sequence \\%c", l.ch)
+		return
+	}
+
+	l.readChar()
+	start := l.readPosition
+	for l.peekChar() != '}' && l.peekChar() != '"' && l.peekChar() != 0 {
+		l.readChar()
+	}
+	digits := l.input[start:l.readPosition]
+	if l.peekChar() != '}' {
+		l.addError(pos, "unterminated unicode escape \\u{%s", digits)
+		return
+	}
+	l.readChar()
+
+	code, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || len(digits) > 6 || !utf8.ValidRune(rune(code)) {
+		l.addError(pos, "invalid unicode escape \\u{%s}", digits)
+		return
+	}
+	out.WriteRune(rune(code))
 }
@@ -14,7 +14,7 @@ func TestNextToken(t *testing.T) {
 	};
 	
 	let result = add(five, ten);
-	!-/*5;
+	!-/ *5;
 	5 < 10 > 5;
 
 	if (5 < 10) {
@@ -138,3 +138,300 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestShebangLine(t *testing.T) {
+	input := "#!/usr/bin/env monkey run\nlet x = 1;"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENTIFIER, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  x + \"a\";\n"
+
+	tests := []struct {
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{"let", 1, 1},
+		{"x", 1, 5},
+		{"=", 1, 7},
+		{"5", 1, 9},
+		{";", 1, 10},
+		{"x", 2, 3},
+		{"+", 2, 5},
+		{"a", 2, 7},
+		{";", 2, 10},
+		{"", 3, 1},
+	}
+
+	l := NewWithFile("script.mk", input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d", i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+		if tok.Pos.File != "script.mk" {
+			t.Fatalf("tests[%d] - file wrong. expected=%q, got=%q", i, "script.mk", tok.Pos.File)
+		}
+	}
+}
+
+func TestNumberTokens(t *testing.T) {
+	input := `5 5.25 0.5 1e3 2.5E-3 7e+2 3.foo 4e`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "5"},
+		{token.FLOAT, "5.25"},
+		{token.FLOAT, "0.5"},
+		{token.FLOAT, "1e3"},
+		{token.FLOAT, "2.5E-3"},
+		{token.FLOAT, "7e+2"},
+		{token.INT, "3"},
+		{token.ILLEGAL, "."},
+		{token.IDENTIFIER, "foo"},
+		{token.INT, "4"},
+		{token.IDENTIFIER, "e"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestCompoundAssignTokens(t *testing.T) {
+	input := `a += 1; a -= 1; a *= 2; a /= 2; a = -a;`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENTIFIER, "a"}, {token.PLUS_ASSIGN, "+="}, {token.INT, "1"}, {token.SEMICOLON, ";"},
+		{token.IDENTIFIER, "a"}, {token.MINUS_ASSIGN, "-="}, {token.INT, "1"}, {token.SEMICOLON, ";"},
+		{token.IDENTIFIER, "a"}, {token.ASTERISK_ASSIGN, "*="}, {token.INT, "2"}, {token.SEMICOLON, ";"},
+		{token.IDENTIFIER, "a"}, {token.SLASH_ASSIGN, "/="}, {token.INT, "2"}, {token.SEMICOLON, ";"},
+		{token.IDENTIFIER, "a"}, {token.ASSIGN, "="}, {token.MINUS, "-"}, {token.IDENTIFIER, "a"}, {token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// a line comment
+let a = 1; // trailing
+/* a block
+   comment /* with a nested */ block */
+/// Documents b.
+///   Second line.
+let b = a / 2; //// not a doc comment
+/* unterminated`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENTIFIER, "a"},
+		{token.ASSIGN, "="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.DOC_COMMENT, "Documents b."},
+		{token.DOC_COMMENT, "Second line."},
+		{token.LET, "let"},
+		{token.IDENTIFIER, "b"},
+		{token.ASSIGN, "="},
+		{token.IDENTIFIER, "a"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	expectedErrors := []string{"8:1: unterminated block comment"}
+	if len(l.Errors()) != len(expectedErrors) || l.Errors()[0].Error() != expectedErrors[0] {
+		t.Errorf("Wrong lexer errors. Wanted=%q, got=%q instead.", expectedErrors, l.Errors())
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedString string
+		expectedErrors []string
+	}{
+		{`"a\nb\tc\r"`, "a\nb\tc\r", nil},
+		{`"say \"hi\" \\o/"`, `say "hi" \o/`, nil},
+		{`"\u{48}\u{e9}\u{1F600}"`, "Hé😀", nil},
+		{`"héllo wörld"`, "héllo wörld", nil},
+		{`"bad \q"`, "bad ", []string{"1:6: invalid escape sequence \\q"}},
+		{`"\u{110000}"`, "", []string{"1:2: invalid unicode escape \\u{110000}"}},
+		{`"\u{41"`, "", []string{"1:2: unterminated unicode escape \\u{41"}},
+		{`"open`, "open", []string{"1:1: unterminated string"}},
+		{`"trailing \`, "trailing ", []string{"1:1: unterminated string"}},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != token.STRING {
+			t.Fatalf("%s - tokentype wrong. expected=%q, got=%q", tt.input, token.STRING, tok.Type)
+		}
+		if tok.Literal != tt.expectedString {
+			t.Errorf("%s - literal wrong. expected=%q, got=%q", tt.input, tt.expectedString, tok.Literal)
+		}
+		if len(l.Errors()) != len(tt.expectedErrors) {
+			t.Errorf("%s - wrong errors. expected=%q, got=%q", tt.input, tt.expectedErrors, l.Errors())
+			continue
+		}
+		for i, err := range l.Errors() {
+			if err.Error() != tt.expectedErrors[i] {
+				t.Errorf("%s - wrong error. expected=%q, got=%q", tt.input, tt.expectedErrors[i], err)
+			}
+		}
+	}
+}
+
+func TestUnicodeIdentifiers(t *testing.T) {
+	input := `let größe = "ü"; π`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedColumn  int
+	}{
+		{token.LET, "let", 1},
+		{token.IDENTIFIER, "größe", 5},
+		{token.ASSIGN, "=", 11},
+		{token.STRING, "ü", 13},
+		{token.SEMICOLON, ";", 16},
+		{token.IDENTIFIER, "π", 18},
+		{token.EOF, "", 19},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - column wrong. expected=%d, got=%d", i, tt.expectedColumn, tok.Pos.Column)
+		}
+	}
+}
+
+func TestStringInterpolation(t *testing.T) {
+	input := `"hello ${name}, you are ${ {"age": 1}["age"] + 1 }" "\${x}" "${"in"}"`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.TEMPLATE_PART, "hello "},
+		{token.IDENTIFIER, "name"},
+		{token.TEMPLATE_PART, ", you are "},
+		{token.LBRACE, "{"},
+		{token.STRING, "age"},
+		{token.COLON, ":"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.STRING, "age"},
+		{token.RBRACKET, "]"},
+		{token.PLUS, "+"},
+		{token.INT, "1"},
+		{token.TEMPLATE_END, ""},
+		{token.STRING, "${x}"},
+		{token.TEMPLATE_PART, ""},
+		{token.STRING, "in"},
+		{token.TEMPLATE_END, ""},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestOperatorTokens(t *testing.T) {
+	input := `% <= >= && || & | ^ ~ << >> < >`
+
+	expected := []token.TokenType{
+		token.PERCENT, token.LT_EQ, token.GT_EQ, token.AND, token.OR, token.BIT_AND, token.BIT_OR,
+		token.BIT_XOR, token.BIT_NOT, token.SHIFT_LEFT, token.SHIFT_RIGHT, token.LT, token.GT, token.EOF,
+	}
+
+	l := New(input)
+	for i, expectedType := range expected {
+		tok := l.NextToken()
+		if tok.Type != expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, expectedType, tok.Type)
+		}
+	}
+}
@@ -1,17 +1,95 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"monkey-int/repl"
 	"os"
-	"os/user"
 )
 
+const usage = `Usage:
+  monkey [repl] [--engine=eval|vm] [--checked]
+  monkey run [--engine=eval|vm] [--checked] <script.mk|script.mkc> [args...]
+  monkey build <script.mk> [-o script.mkc]
+  monkey disasm <script.mk>
+
+Options:
+  --engine   evaluation engine: "vm" (bytecode compiler and virtual machine, default)
+             or "eval" (tree-walking interpreter)
+  -int       shorthand for --engine=eval
+  --checked  report integer overflow as a runtime error instead of wrapping around
+  -o         output file of build, defaults to the script name with the .mkc extension
+`
+
 func main() {
-	user, err := user.Current()
-	if err != nil {
-		panic(err)
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, in io.Reader, out, errOut io.Writer) int {
+	command := "repl"
+	if len(args) > 0 && (args[0] == "repl" || args[0] == "run" || args[0] == "build" || args[0] == "disasm") {
+		command = args[0]
+		args = args[1:]
+	}
+
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	flags.Usage = func() { fmt.Fprint(errOut, usage) }
+	engine := flags.String("engine", repl.EngineVM, "")
+	useInterpreter := flags.Bool("int", false, "")
+	checked := flags.Bool("checked", false, "")
+	output := ""
+	if command == "build" {
+		flags.StringVar(&output, "o", "", "")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *useInterpreter {
+		*engine = repl.EngineEval
+	}
+	if *engine != repl.EngineVM && *engine != repl.EngineEval {
+		fmt.Fprintf(errOut, "unknown engine %q\n", *engine)
+		return 2
+	}
+	opts := repl.Options{Engine: *engine, CheckedArithmetic: *checked}
+
+	switch command {
+	case "run":
+		if flags.NArg() < 1 {
+			fmt.Fprint(errOut, usage)
+			return 2
+		}
+		return runFile(flags.Arg(0), flags.Args()[1:], opts, errOut)
+	case "build":
+		if flags.NArg() < 1 {
+			fmt.Fprint(errOut, usage)
+			return 2
+		}
+		// the output flag may also follow the script name
+		script := flags.Arg(0)
+		if err := flags.Parse(flags.Args()[1:]); err != nil {
+			return 2
+		}
+		if flags.NArg() > 0 {
+			fmt.Fprint(errOut, usage)
+			return 2
+		}
+		return buildFile(script, output, errOut)
+	case "disasm":
+		if flags.NArg() != 1 {
+			fmt.Fprint(errOut, usage)
+			return 2
+		}
+		return disasmFile(flags.Arg(0), out, errOut)
+	default:
+		if flags.NArg() > 0 {
+			fmt.Fprint(errOut, usage)
+			return 2
+		}
+		fmt.Fprint(out, "== MONKEY INTERPRETER ==\n")
+		repl.Start(in, out, opts)
+		return 0
 	}
-	fmt.Printf("== MONKEY INTERPRETER ==\n", user.Username)
-	repl.Start(os.Stdin, os.Stdout)
 }
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeScript(t *testing.T, src string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "script.mk")
+	err := os.WriteFile(filename, []byte(src), 0644)
+	if err != nil {
+		t.Fatalf("could not write script: %s", err)
+	}
+	return filename
+}
+
+func TestRunScript(t *testing.T) {
+	tests := []struct {
+		src              string
+		args             []string
+		expectedExitCode int
+		expectedStderr   string
+	}{
+		{"let x = 1; x + 1;", nil, 0, ""},
+		{"#!/usr/bin/env monkey run\nlet x = 1;", nil, 0, ""},
+		{`if (len(args) != 2) { 1 + true }; args[1]`, []string{"a", "b"}, 0, ""},
+		{`let x = ;`, nil, 1, "expected expression, found"},
+		{"let a = 1;\na + true", nil, 1, "script.mk:2:3: "},
+		{`unknown`, nil, 1, "unknown"},
+		{"let x = 1;\nx / 0", nil, 1, "script.mk:2:3: division by zero"},
+	}
+
+	for _, engine := range []string{"vm", "eval"} {
+		for _, tt := range tests {
+			filename := writeScript(t, tt.src)
+			var stdout, stderr bytes.Buffer
+			args := append([]string{"run", "--engine=" + engine, filename}, tt.args...)
+
+			code := run(args, strings.NewReader(""), &stdout, &stderr)
+			if code != tt.expectedExitCode {
+				t.Errorf("[%s] %q: wrong exit code. Wanted=%d, got=%d instead (stderr=%q).", engine, tt.src, tt.expectedExitCode, code, stderr.String())
+			}
+			if tt.expectedStderr != "" && !strings.Contains(stderr.String(), tt.expectedStderr) {
+				t.Errorf("[%s] %q: stderr %q does not contain %q", engine, tt.src, stderr.String(), tt.expectedStderr)
+			}
+		}
+	}
+}
+
+func TestRunUsageErrors(t *testing.T) {
+	tests := [][]string{
+		{"run"},
+		{"run", "--engine=jit", "script.mk"},
+		{"repl", "unexpected"},
+		{"disasm"},
+		{"build"},
+		{"build", "a.mk", "b.mk"},
+	}
+
+	for _, args := range tests {
+		var stdout, stderr bytes.Buffer
+		code := run(args, strings.NewReader(""), &stdout, &stderr)
+		if code != 2 {
+			t.Errorf("%v: wrong exit code. Wanted=%d, got=%d instead.", args, 2, code)
+		}
+	}
+}
+
+func TestRunCheckedArithmetic(t *testing.T) {
+	filename := writeScript(t, "9223372036854775807 + 1")
+
+	for _, engine := range []string{"vm", "eval"} {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"run", "--engine=" + engine, filename}, strings.NewReader(""), &stdout, &stderr)
+		if code != 0 {
+			t.Errorf("[%s] wrapping run: wrong exit code. Wanted=0, got=%d instead (stderr=%q).", engine, code, stderr.String())
+		}
+
+		stderr.Reset()
+		code = run([]string{"run", "--engine=" + engine, "--checked", filename}, strings.NewReader(""), &stdout, &stderr)
+		if code != 1 {
+			t.Errorf("[%s] checked run: wrong exit code. Wanted=1, got=%d instead.", engine, code)
+		}
+		if !strings.Contains(stderr.String(), "integer overflow: 9223372036854775807 + 1") {
+			t.Errorf("[%s] checked run: unexpected stderr %q", engine, stderr.String())
+		}
+	}
+}
+
+func TestDisasm(t *testing.T) {
+	filename := writeScript(t, "let x = args;\nputs(\"hi\")")
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"disasm", filename}, strings.NewReader(""), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("Wrong exit code. Wanted=0, got=%d instead (stderr=%q).", code, stderr.String())
+	}
+	for _, expected := range []string{"0000 \"hi\"", "; 2: puts(\"hi\")", "OpGetBuiltin 1           ; puts"} {
+		if !strings.Contains(stdout.String(), expected) {
+			t.Errorf("Disassembly %q does not contain %q", stdout.String(), expected)
+		}
+	}
+
+	filename = writeScript(t, "let x = ;")
+	stdout.Reset()
+	if code := run([]string{"disasm", filename}, strings.NewReader(""), &stdout, &stderr); code != 1 {
+		t.Errorf("Wrong exit code for a parse error. Wanted=1, got=%d instead.", code)
+	}
+}
+
+func TestBuildAndRunCompiled(t *testing.T) {
+	filename := writeScript(t, "let f = fn(x) { x / 0 };\nif (len(args) == 1) { puts(args[0]) } else { f(1) }")
+	compiled := filepath.Join(filepath.Dir(filename), "out.mkc")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"build", filename, "-o", compiled}, strings.NewReader(""), &stdout, &stderr); code != 0 {
+		t.Fatalf("Wrong exit code for build. Wanted=0, got=%d instead (stderr=%q).", code, stderr.String())
+	}
+
+	if code := run([]string{"run", compiled, "hi"}, strings.NewReader(""), &stdout, &stderr); code != 0 {
+		t.Errorf("Wrong exit code. Wanted=0, got=%d instead (stderr=%q).", code, stderr.String())
+	}
+
+	stderr.Reset()
+	if code := run([]string{"run", compiled}, strings.NewReader(""), &stdout, &stderr); code != 1 {
+		t.Errorf("Wrong exit code for a runtime error. Wanted=1, got=%d instead.", code)
+	}
+	if !strings.Contains(stderr.String(), "script.mk:1:19: division by zero") || !strings.Contains(stderr.String(), "at f (") {
+		t.Errorf("Runtime error of the compiled script lost its position: %q", stderr.String())
+	}
+
+	stderr.Reset()
+	if code := run([]string{"run", "--engine=eval", compiled}, strings.NewReader(""), &stdout, &stderr); code != 1 {
+		t.Errorf("Wrong exit code for the eval engine. Wanted=1, got=%d instead.", code)
+	}
+
+	data, _ := os.ReadFile(compiled)
+	os.WriteFile(compiled, data[:len(data)-2], 0644)
+	stderr.Reset()
+	if code := run([]string{"run", compiled}, strings.NewReader(""), &stdout, &stderr); code != 1 {
+		t.Errorf("Wrong exit code for a corrupted file. Wanted=1, got=%d instead.", code)
+	}
+	if !strings.Contains(stderr.String(), "truncated bytecode file") {
+		t.Errorf("Unexpected stderr for a corrupted file: %q", stderr.String())
+	}
+
+	if code := run([]string{"build", filename}, strings.NewReader(""), &stdout, &stderr); code != 0 {
+		t.Fatalf("Wrong exit code for build. Wanted=0, got=%d instead.", code)
+	}
+	if _, err := os.Stat(strings.TrimSuffix(filename, ".mk") + ".mkc"); err != nil {
+		t.Errorf("Default output file not written: %s", err)
+	}
+}
+
+func TestRunImports(t *testing.T) {
+	filename := writeScript(t, "import \"lib/math.mk\" as m\nif (m[\"square\"](3) != 9) { 1 / 0 }\nm[\"check\"](-1)")
+	err := os.MkdirAll(filepath.Join(filepath.Dir(filename), "lib"), 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(filepath.Dir(filename), "lib", "math.mk"), []byte("let square = fn(x) { x * x };\nlet check = fn(x) { if (x < 0) { throw(\"negative\") } };"), 0644)
+	}
+	if err != nil {
+		t.Fatalf("could not write module: %s", err)
+	}
+
+	compiled := filepath.Join(t.TempDir(), "script.mkc")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"build", filename, "-o", compiled}, strings.NewReader(""), &stdout, &stderr); code != 0 {
+		t.Fatalf("Wrong exit code for build. Wanted=0, got=%d instead (stderr=%q).", code, stderr.String())
+	}
+
+	for _, args := range [][]string{{"run", "--engine=vm", filename}, {"run", "--engine=eval", filename}, {"run", compiled}} {
+		stderr.Reset()
+		code := run(args, strings.NewReader(""), &stdout, &stderr)
+		if code != 1 {
+			t.Errorf("%v: wrong exit code. Wanted=1, got=%d instead (stderr=%q).", args, code, stderr.String())
+		}
+		expected := "math.mk:2:39: negative\n\tat check ("
+		if !strings.Contains(stderr.String(), expected) || !strings.Contains(stderr.String(), "script.mk:3:11)") {
+			t.Errorf("%v: stderr %q does not contain the error raised in the module", args, stderr.String())
+		}
+	}
+}
+
+func TestReplDiscardsSymbolsOfFailedLines(t *testing.T) {
+	in := strings.NewReader("let a = 1; let b = nope;\na + 1\nlet a = 1;\na + 1\n")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"repl"}, in, &stdout, &stderr); code != 0 {
+		t.Fatalf("wrong exit code. Wanted=0, got=%d instead. stderr=%q", code, stderr.String())
+	}
+	output := stdout.String()
+	if !strings.Contains(output, "Unknown symbol: nope") {
+		t.Errorf("missing compilation error, got=%q", output)
+	}
+	if !strings.Contains(output, "Unknown symbol: a") {
+		t.Errorf("a defined by a line that failed to compile, got=%q", output)
+	}
+	if !strings.Contains(output, ">> 2\n") {
+		t.Errorf("a + 1 not evaluated, got=%q", output)
+	}
+	if strings.Contains(output, "internal error") {
+		t.Errorf("unexpected internal error, got=%q", output)
+	}
+}
+
+func TestReplReadsBindingsOfFailedLines(t *testing.T) {
+	in := strings.NewReader("let a = 1 / 0;\na + 1\n")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"repl"}, in, &stdout, &stderr); code != 0 {
+		t.Fatalf("wrong exit code. Wanted=0, got=%d instead. stderr=%q", code, stderr.String())
+	}
+	output := stdout.String()
+	if !strings.Contains(output, "division by zero") {
+		t.Errorf("missing runtime error, got=%q", output)
+	}
+	// a is known to the compiler, but its let never set it
+	if !strings.Contains(output, "identifier not found: a") {
+		t.Errorf("wrong error reading a, got=%q", output)
+	}
+	if strings.Contains(output, "internal error") {
+		t.Errorf("unexpected internal error, got=%q", output)
+	}
+}
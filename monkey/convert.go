@@ -0,0 +1,143 @@
+package monkey
+
+import (
+	"fmt"
+	"math"
+	"monkey-int/object"
+	"reflect"
+)
+
+// ToGo converts a Monkey value to Go: integers to int64, floats to float64, strings, booleans,
+// null to nil, arrays to []any and hashes to map[string]any. Functions and other values
+// without a Go counterpart are returned as they are, so they can be passed back to Monkey.
+func ToGo(obj object.Object) (any, error) {
+	return toGo(obj, map[object.Object]bool{}, 0)
+}
+
+// MaxConvertDepth is the deepest nesting of arrays and hashes ToGo converts
+const MaxConvertDepth = 10000
+
+// toGo converts obj, failing for arrays and hashes that contain themselves, which have no
+// counterpart in Go, and for arrays and hashes nested deeper than MaxConvertDepth.
+func toGo(obj object.Object, seen map[object.Object]bool, depth int) (any, error) {
+	if depth > MaxConvertDepth {
+		return nil, fmt.Errorf("cannot convert arrays and hashes nested deeper than %d", MaxConvertDepth)
+	}
+
+	switch obj := obj.(type) {
+	case nil, *object.Null:
+		return nil, nil
+	case *object.Integer:
+		return obj.Value, nil
+	case *object.Float:
+		return obj.Value, nil
+	case *object.String:
+		return obj.Value, nil
+	case *object.Boolean:
+		return obj.Value, nil
+	case *object.Array:
+		if seen[obj] {
+			return nil, fmt.Errorf("cannot convert array containing itself")
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+		elements := make([]any, len(obj.Elements))
+		for i, element := range obj.Elements {
+			value, err := toGo(element, seen, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = value
+		}
+		return elements, nil
+	case *object.Hash:
+		if seen[obj] {
+			return nil, fmt.Errorf("cannot convert hash containing itself")
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+		pairs := make(map[string]any, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			key, ok := pair.Key.(*object.String)
+			if !ok {
+				return nil, fmt.Errorf("cannot convert hash with %s key to map[string]any", pair.Key.Type())
+			}
+			value, err := toGo(pair.Value, seen, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			pairs[key.Value] = value
+		}
+		return pairs, nil
+	}
+	return obj, nil
+}
+
+// FromGo converts a Go value to Monkey: integers, floats, strings, booleans, nil, slices,
+// arrays and maps with string keys. Values that already are an object.Object are used as they are.
+func FromGo(v any) (object.Object, error) {
+	switch v := v.(type) {
+	case nil:
+		return object.NULL, nil
+	case object.Object:
+		return v, nil
+	case bool:
+		if v {
+			return object.TRUE, nil
+		}
+		return object.FALSE, nil
+	case string:
+		return &object.String{Value: v}, nil
+	}
+
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: value.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if value.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows a Monkey integer", value.Uint())
+		}
+		return &object.Integer{Value: int64(value.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: value.Float()}, nil
+	case reflect.String:
+		return &object.String{Value: value.String()}, nil
+	case reflect.Bool:
+		return FromGo(value.Bool())
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			return object.NULL, nil
+		}
+		elements := make([]object.Object, value.Len())
+		for i := range elements {
+			element, err := FromGo(value.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("cannot convert %T to a Monkey hash, keys must be strings", v)
+		}
+		pairs := make(map[object.HashKey]object.HashPair, value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			key := &object.String{Value: iter.Key().String()}
+			element, err := FromGo(iter.Value().Interface())
+			if err != nil {
+				return nil, err
+			}
+			pairs[key.HashKey()] = object.HashPair{Key: key, Value: element}
+		}
+		return &object.Hash{Pairs: pairs}, nil
+	case reflect.Pointer, reflect.Interface:
+		if value.IsNil() {
+			return object.NULL, nil
+		}
+		return FromGo(value.Elem().Interface())
+	}
+	return nil, fmt.Errorf("cannot convert %T to a Monkey value", v)
+}
@@ -0,0 +1,319 @@
+// Package monkey embeds the Monkey interpreter in Go programs:
+//
+//	rt, _ := monkey.NewRuntime(monkey.Options{})
+//	rt.SetGlobal("name", "world")
+//	greeting, err := rt.Eval(ctx, `"hello " + name`)
+//
+// A Runtime keeps its globals between calls, so functions defined by one Eval can be
+// called by later ones or from Go with Call. A Runtime must not be used concurrently.
+package monkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"monkey-int/ast"
+	"monkey-int/bytecode"
+	"monkey-int/compiler"
+	"monkey-int/evaluator"
+	"monkey-int/lexer"
+	"monkey-int/object"
+	"monkey-int/parser"
+	"monkey-int/token"
+	"monkey-int/vm"
+	"strings"
+)
+
+// Engines a Runtime can execute scripts with
+const (
+	EngineVM   = "vm"   // bytecode compiler and virtual machine
+	EngineEval = "eval" // tree-walking interpreter
+)
+
+// Options configures a Runtime
+type Options struct {
+	Engine string // EngineVM (the default) or EngineEval
+
+	// CheckedArithmetic makes integer overflow an error instead of wrapping around
+	CheckedArithmetic bool
+
+	// Filename is used in error positions and imports are resolved relative to it,
+	// relative to the working directory if empty
+	Filename string
+
+	// Budget limits every call of Eval and Call, exceeding it fails the call with an
+	// error wrapping object.ErrBudgetExceeded
+	Budget object.Budget
+
+	// Sandbox restricts the files readfile and writefile can access and the modules that
+	// can be imported, nil allows any file
+	Sandbox *object.Sandbox
+}
+
+// Runtime executes Monkey code with one of the engines
+type Runtime struct {
+	opts Options
+
+	// state of the tree-walking interpreter
+	ctx *object.Context
+
+	// state of the virtual machine
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+}
+
+// SyntaxError reports the diagnostics of source code that could not be parsed
+type SyntaxError struct {
+	Diagnostics []parser.Diagnostic
+}
+
+func (e *SyntaxError) Error() string {
+	messages := make([]string, len(e.Diagnostics))
+	for i, diagnostic := range e.Diagnostics {
+		messages[i] = diagnostic.String()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Error is a runtime error that was not caught by the script
+type Error struct {
+	Message string
+	Pos     token.Position
+	Stack   []object.StackFrame // Monkey call stack, innermost first
+
+	cause error
+}
+
+func (e *Error) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Message
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+func NewRuntime(opts Options) (*Runtime, error) {
+	if opts.Engine == "" {
+		opts.Engine = EngineVM
+	}
+	r := &Runtime{opts: opts}
+	switch opts.Engine {
+	case EngineEval:
+		r.ctx = object.NewContext()
+		r.ctx.CheckedArithmetic = opts.CheckedArithmetic
+		r.ctx.Sandbox = opts.Sandbox
+	case EngineVM:
+		r.symbolTable = compiler.NewSymbolTable()
+		for i, v := range object.Builtins {
+			r.symbolTable.DefineBuiltin(i, v.Name)
+		}
+		r.constants = []object.Object{}
+		r.globals = make([]object.Object, vm.GlobalsSize)
+	default:
+		return nil, fmt.Errorf("unknown engine %q", opts.Engine)
+	}
+	return r, nil
+}
+
+// Eval runs src and returns the value of its last statement converted with ToGo, or nil if
+// the last statement is neither an expression nor a return. The run is aborted once ctx is done.
+func (r *Runtime) Eval(ctx context.Context, src string) (any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p := parser.New(lexer.NewWithFile(r.opts.Filename, src))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, &SyntaxError{Diagnostics: p.Errors()}
+	}
+
+	var result object.Object
+	if r.opts.Engine == EngineEval {
+		result = evaluator.EvalWithBudget(ctx, program, r.ctx, r.opts.Budget)
+		if err := asError(ctx, result); err != nil {
+			return nil, err
+		}
+	} else {
+		// the symbols are only kept if the whole program compiles, the globals of a program
+		// that failed to compile are never set
+		symbolTable := r.symbolTable.Copy()
+		comp := compiler.NewWithState(symbolTable, r.constants)
+		comp.Sandbox = r.opts.Sandbox
+		if err := comp.Compile(program); err != nil {
+			return nil, err
+		}
+		code := comp.Bytecode()
+		r.symbolTable = symbolTable
+		r.constants = code.Constants
+
+		var err error
+		result, err = r.run(ctx, code)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(program.Statements) == 0 {
+		return nil, nil
+	}
+	switch program.Statements[len(program.Statements)-1].(type) {
+	case *ast.ExpressionStatement, *ast.ReturnStatement:
+		return ToGo(result)
+	}
+	return nil, nil
+}
+
+// Call calls the global function fnName with args converted with FromGo and returns
+// its result converted with ToGo
+func (r *Runtime) Call(fnName string, args ...any) (any, error) {
+	return r.CallContext(context.Background(), fnName, args...)
+}
+
+// CallContext is like Call, but aborts the call once ctx is done
+func (r *Runtime) CallContext(ctx context.Context, fnName string, args ...any) (any, error) {
+	fn, ok := r.global(fnName)
+	if !ok {
+		return nil, fmt.Errorf("global %q is not defined", fnName)
+	}
+	switch fn.(type) {
+	case *object.Function, *object.Closure, *object.Builtin:
+	default:
+		return nil, fmt.Errorf("global %q is not a function, got %s", fnName, fn.Type())
+	}
+
+	// the limit of OpCall applies to both engines, as it does for calls in Monkey code
+	if len(args) > math.MaxUint8 {
+		return nil, fmt.Errorf("too many arguments (max %d)", math.MaxUint8)
+	}
+	arguments := make([]object.Object, len(args))
+	for i, arg := range args {
+		obj, err := FromGo(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i, err)
+		}
+		arguments[i] = obj
+	}
+
+	var result object.Object
+	if r.opts.Engine == EngineEval {
+		result = evaluator.ApplyWithBudget(ctx, fn, arguments, r.ctx, r.opts.Budget)
+		if err := asError(ctx, result); err != nil {
+			return nil, err
+		}
+	} else {
+		// the function and its arguments are pushed as constants of a program calling it
+		if len(r.constants)+1+len(arguments) > math.MaxUint16+1 {
+			return nil, fmt.Errorf("too many constants (max %d)", math.MaxUint16+1)
+		}
+		constants := append(append([]object.Object{}, r.constants...), fn)
+		ins := bytecode.Make(bytecode.OpConstant, len(constants)-1)
+		for _, arg := range arguments {
+			constants = append(constants, arg)
+			ins = append(ins, bytecode.Make(bytecode.OpConstant, len(constants)-1)...)
+		}
+		ins = append(ins, bytecode.Make(bytecode.OpCall, len(arguments))...)
+		ins = append(ins, bytecode.Make(bytecode.OpPop)...)
+
+		var err error
+		result, err = r.run(ctx, &compiler.MyBytecode{Instructions: ins, Constants: constants, GlobalNames: r.symbolTable.GlobalNames()})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ToGo(result)
+}
+
+// SetGlobal defines or rebinds the global name to value converted with FromGo. name must be
+// an identifier scripts can refer to, builtins cannot be shadowed.
+func (r *Runtime) SetGlobal(name string, value any) error {
+	if !object.IsIdentifier(name) {
+		return fmt.Errorf("invalid global name %q", name)
+	}
+	if object.GetBuiltinByName(name) != nil {
+		return fmt.Errorf("cannot assign to builtin %q", name)
+	}
+	obj, err := FromGo(value)
+	if err != nil {
+		return err
+	}
+	if r.opts.Engine == EngineEval {
+		r.ctx.Set(name, obj)
+		return nil
+	}
+	symbol := r.symbolTable.Define(name)
+	r.globals[symbol.Index] = obj
+	return nil
+}
+
+// GetGlobal returns the value of the global name converted with ToGo
+func (r *Runtime) GetGlobal(name string) (any, error) {
+	obj, ok := r.global(name)
+	if !ok {
+		return nil, fmt.Errorf("global %q is not defined", name)
+	}
+	return ToGo(obj)
+}
+
+// global looks up a global or builtin by name
+func (r *Runtime) global(name string) (object.Object, bool) {
+	if r.opts.Engine == EngineEval {
+		if obj, ok := r.ctx.Get(name); ok {
+			return obj, true
+		}
+		builtin := object.GetBuiltinByName(name)
+		return builtin, builtin != nil
+	}
+
+	symbol, ok := r.symbolTable.Resolve(name)
+	if !ok {
+		return nil, false
+	}
+	switch symbol.Scope {
+	case compiler.GlobalScope:
+		obj := r.globals[symbol.Index]
+		return obj, obj != nil
+	case compiler.BuiltinScope:
+		return object.Builtins[symbol.Index].Value, true
+	}
+	return nil, false
+}
+
+// run executes bytecode on a virtual machine sharing the globals of the runtime
+func (r *Runtime) run(ctx context.Context, code *compiler.MyBytecode) (object.Object, error) {
+	machine := vm.NewWithGlobalsStore(code, r.globals)
+	machine.CheckedArithmetic = r.opts.CheckedArithmetic
+	machine.Sandbox = r.opts.Sandbox
+	err := machine.RunWithBudget(ctx, r.opts.Budget)
+	var runtimeErr *vm.RuntimeError
+	if errors.As(err, &runtimeErr) {
+		return nil, &Error{Message: runtimeErr.Err.Error(), Pos: runtimeErr.Pos, Stack: runtimeErr.Stack, cause: runtimeErr.Err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := asError(ctx, machine.LastPoppedStackElem()); err != nil {
+		return nil, err
+	}
+	return machine.LastPoppedStackElem(), nil
+}
+
+// asError returns the error an engine evaluated to within ctx, if any
+func asError(ctx context.Context, obj object.Object) error {
+	if err, ok := obj.(*object.Error); ok {
+		runtimeErr := &Error{Message: err.Message, Pos: err.Pos, Stack: err.Stack}
+		if err.ErrorKind() == object.BudgetExceededKind {
+			runtimeErr.cause = object.ErrBudgetExceeded
+			if ctx.Err() != nil {
+				runtimeErr.cause = fmt.Errorf("%w: %w", object.ErrBudgetExceeded, ctx.Err())
+			}
+		}
+		return runtimeErr
+	}
+	return nil
+}
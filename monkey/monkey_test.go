@@ -0,0 +1,667 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"monkey-int/object"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var engines = []string{EngineVM, EngineEval}
+
+func newRuntime(t *testing.T, engine string) *Runtime {
+	t.Helper()
+	r, err := NewRuntime(Options{Engine: engine, Filename: "embed.mk"})
+	if err != nil {
+		t.Fatalf("could not create runtime: %s", err)
+	}
+	return r
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"1 + 2", int64(3)},
+		{"1.5 * 2", 3.0},
+		{`"a" + "b"`, "ab"},
+		{"1 < 2", true},
+		{"if (false) { 1 }", nil},
+		{"let x = 1;", nil},
+		{"return 5;", int64(5)},
+		{"let x = 1; if (x > 0) { return x + 1; } 10", int64(2)},
+		{"", nil},
+		{`[1, "two", [true]]`, []any{int64(1), "two", []any{true}}},
+		{`{"a": 1, "b": [if (false) { 1 }]}`, map[string]any{"a": int64(1), "b": []any{nil}}},
+		// the target of an assignment is evaluated before the assigned value
+		{"let i = 0; let a = [10, 20]; a[i] = (i = 1); a", []any{int64(1), int64(20)}},
+		{"let x = 1; x += (x = 5); x", int64(6)},
+		{"let a = [1, 2]; let b = a; let f = fn() { a = [3, 4]; 5 }; a[0] = f(); [a, b]", []any{[]any{int64(3), int64(4)}, []any{int64(5), int64(2)}}},
+		{`let h = {"n": 1}; h["n"] += (h["n"] = 10); h["n"]`, int64(11)},
+		// break, continue and return within an expression end the enclosing statement
+		{"let n = 0; for (x in [1, 2, 3, 4]) { let v = if (x > 2) { break; } else { x }; n = n + 1; }; n", int64(2)},
+		{"let r = 0; for (x in [1, 2, 3]) { r = r + try { if (x == 2) { continue; } x } catch (e) { 0 }; }; r", int64(4)},
+		{"let r = []; let i = 0; while (i < 3) { i = i + 1; r = push(r, [i, if (i == 2) { continue; } else { i }]); }; len(r)", int64(2)},
+		{"let f = fn() { let v = 1 + if (true) { return 1; } else { 2 }; 3 }; f()", int64(1)},
+		// a function refers to itself through the variable it is bound to, which can be reassigned
+		{"let f = fn() { f = 1; }; f(); f", int64(1)},
+		{"let f = fn() { fn() { f = 2; } }; f()(); f", int64(2)},
+		{"let g = fn() { let f = fn() { f = 3; f }; let r = f(); [r, f] }; g()", []any{int64(3), int64(3)}},
+		{"let f = fn() { 1 }; let g = f; f = fn() { 2 }; g()", int64(1)},
+		{"let f = fn(n) { if (n < 1) { 0 } else { f(n - 1) } }; let g = f; f = fn(n) { 9 }; g(1)", int64(9)},
+		{"let g = fn() { let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(5) }; g()", int64(120)},
+		// the catch parameter is bound for the handler only, the other lets of the handler stay
+		{"let e = 1; try { 1 / 0 } catch (e) { 2 }; e", int64(1)},
+		{"let e = 1; try { 1 / 0 } catch (e) { e = 5; let e = 6; }; e", int64(1)},
+		{"let f = fn() { let e = 1; try { 1 / 0 } catch (e) { 2 }; e }; f()", int64(1)},
+		{"let e = 1; let f = fn() { try { 1 / 0 } catch (e) { 2 }; e }; f()", int64(1)},
+		{"try { 1 / 0 } catch (e) { let m = 3; }; m", int64(3)},
+		{`let h = try { 1 / 0 } catch (e) { fn() { e["message"] } }; let e = 1; h()`, "division by zero"},
+		{`try { 1 / 0 } catch (e) { try { e["nope"] } catch (e) { 0 }; e["message"] }`, "division by zero"},
+	}
+
+	for _, engine := range engines {
+		for _, tt := range tests {
+			r := newRuntime(t, engine)
+			result, err := r.Eval(context.Background(), tt.input)
+			if err != nil {
+				t.Errorf("[%s] %q: unexpected error: %s", engine, tt.input, err)
+				continue
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("[%s] %q: wrong result. Wanted=%#v, got=%#v", engine, tt.input, tt.expected, result)
+			}
+		}
+	}
+}
+
+func TestSelfReferences(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let a = [1]; a[0] = a; "${a}"`, "[[...]]"},
+		{`let a = [1]; let b = [a, a]; "${b}"`, "[[1], [1]]"},
+		{`let h = {}; h["self"] = [h]; "${h}"`, "{self: [{...}]}"},
+	}
+
+	for _, engine := range engines {
+		for _, tt := range tests {
+			r := newRuntime(t, engine)
+			result, err := r.Eval(context.Background(), tt.input)
+			if err != nil {
+				t.Errorf("[%s] %q: unexpected error: %s", engine, tt.input, err)
+				continue
+			}
+			if result != tt.expected {
+				t.Errorf("[%s] %q: wrong result. Wanted=%q, got=%#v", engine, tt.input, tt.expected, result)
+			}
+		}
+
+		r := newRuntime(t, engine)
+		_, err := r.Eval(context.Background(), "let a = [1]; a[0] = a; a")
+		if err == nil || err.Error() != "cannot convert array containing itself" {
+			t.Errorf("[%s] wrong error converting a self-referential array. got=%v", engine, err)
+		}
+
+		// deep nesting is an error instead of exhausting the Go stack
+		r = newRuntime(t, engine)
+		input := fmt.Sprintf("let a = []; let i = 0; while (i < %d) { a = [a]; i += 1 }; a", MaxConvertDepth+1)
+		_, err = r.Eval(context.Background(), input)
+		expected := fmt.Sprintf("cannot convert arrays and hashes nested deeper than %d", MaxConvertDepth)
+		if err == nil || err.Error() != expected {
+			t.Errorf("[%s] wrong error converting a deeply nested array. got=%v", engine, err)
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	for _, engine := range engines {
+		r := newRuntime(t, engine)
+		ctx := context.Background()
+
+		if err := r.SetGlobal("name", "world"); err != nil {
+			t.Fatalf("[%s] SetGlobal failed: %s", engine, err)
+		}
+		if err := r.SetGlobal("counts", map[string]int{"a": 1}); err != nil {
+			t.Fatalf("[%s] SetGlobal failed: %s", engine, err)
+		}
+		result, err := r.Eval(ctx, `let greeting = "hello " + name; greeting + "!"`)
+		if err != nil || result != "hello world!" {
+			t.Errorf("[%s] wrong result. got=%#v (%v)", engine, result, err)
+		}
+		result, err = r.Eval(ctx, `counts["a"] + 1`)
+		if err != nil || result != int64(2) {
+			t.Errorf("[%s] wrong result. got=%#v (%v)", engine, result, err)
+		}
+
+		// globals defined by scripts and rebound from Go are visible to later scripts
+		greeting, err := r.GetGlobal("greeting")
+		if err != nil || greeting != "hello world" {
+			t.Errorf("[%s] wrong global. got=%#v (%v)", engine, greeting, err)
+		}
+		if err := r.SetGlobal("name", "again"); err != nil {
+			t.Fatalf("[%s] SetGlobal failed: %s", engine, err)
+		}
+		result, err = r.Eval(ctx, `"hello " + name`)
+		if err != nil || result != "hello again" {
+			t.Errorf("[%s] wrong result. got=%#v (%v)", engine, result, err)
+		}
+
+		if _, err := r.GetGlobal("missing"); err == nil || err.Error() != `global "missing" is not defined` {
+			t.Errorf("[%s] wrong error for undefined global. got=%v", engine, err)
+		}
+		if err := r.SetGlobal("bad", make(chan int)); err == nil || err.Error() != "cannot convert chan int to a Monkey value" {
+			t.Errorf("[%s] wrong error for unconvertible value. got=%v", engine, err)
+		}
+
+		// builtins can't be shadowed and names scripts can't refer to are rejected
+		invalid := map[string]string{
+			"len":  `cannot assign to builtin "len"`,
+			"puts": `cannot assign to builtin "puts"`,
+			"len2": `invalid global name "len2"`,
+			"a b":  `invalid global name "a b"`,
+			"let":  `invalid global name "let"`,
+			"":     `invalid global name ""`,
+		}
+		for name, expected := range invalid {
+			if err := r.SetGlobal(name, 3); err == nil || err.Error() != expected {
+				t.Errorf("[%s] wrong error for SetGlobal(%q). Wanted=%q, got=%v", engine, name, expected, err)
+			}
+		}
+		result, err = r.Eval(ctx, `len("ab")`)
+		if err != nil || result != int64(2) {
+			t.Errorf("[%s] builtin shadowed by SetGlobal. got=%#v (%v)", engine, result, err)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	for _, engine := range engines {
+		r := newRuntime(t, engine)
+		_, err := r.Eval(context.Background(), `
+let add = fn(a, b) { a + b };
+let offset = 10;
+let shift = fn(xs) { push(xs, offset) };
+let fail = fn() { 1 / 0 };
+let number = 1;
+`)
+		if err != nil {
+			t.Fatalf("[%s] Eval failed: %s", engine, err)
+		}
+
+		tests := []struct {
+			fn       string
+			args     []any
+			expected any
+			err      string
+		}{
+			{"add", []any{1, 2}, int64(3), ""},
+			{"add", []any{"a", "b"}, "ab", ""},
+			{"shift", []any{[]int{1, 2}}, []any{int64(1), int64(2), int64(10)}, ""},
+			{"len", []any{"four"}, int64(4), ""},
+			{"fail", nil, nil, "embed.mk:5:21: division by zero"},
+			{"missing", nil, nil, `global "missing" is not defined`},
+			{"number", nil, nil, `global "number" is not a function, got MONKEY_INT`},
+			{"add", []any{uint64(1 << 63), 1}, nil, "argument 0: 9223372036854775808 overflows a Monkey integer"},
+			{"add", make([]any, 256), nil, "too many arguments (max 255)"},
+		}
+
+		for _, tt := range tests {
+			result, err := r.Call(tt.fn, tt.args...)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("[%s] %s: wrong error. Wanted=%q, got=%v", engine, tt.fn, tt.err, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("[%s] %s: unexpected error: %s", engine, tt.fn, err)
+				continue
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("[%s] %s: wrong result. Wanted=%#v, got=%#v", engine, tt.fn, tt.expected, result)
+			}
+		}
+
+		// functions returned to Go can be passed back to Monkey code
+		adder, err := r.GetGlobal("add")
+		if err != nil {
+			t.Fatalf("[%s] GetGlobal failed: %s", engine, err)
+		}
+		if err := r.SetGlobal("plus", adder); err != nil {
+			t.Fatalf("[%s] SetGlobal failed: %s", engine, err)
+		}
+		result, err := r.Call("plus", 2, 3)
+		if err != nil || result != int64(5) {
+			t.Errorf("[%s] wrong result of passed back function. got=%#v (%v)", engine, result, err)
+		}
+	}
+
+	// the VM calls functions from Go with a program loading them as constants
+	r := newRuntime(t, EngineVM)
+	if _, err := r.Eval(context.Background(), "let add = fn(a, b) { a + b };"); err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	r.constants = append(r.constants, make([]object.Object, math.MaxUint16-len(r.constants))...)
+	if _, err := r.Call("add", 1, 2); err == nil || err.Error() != "too many constants (max 65536)" {
+		t.Errorf("wrong error for too many constants. got=%v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, engine := range engines {
+		r := newRuntime(t, engine)
+
+		_, err := r.Eval(context.Background(), "let x = ;")
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) || len(syntaxErr.Diagnostics) != 1 {
+			t.Errorf("[%s] expected a syntax error, got=%v", engine, err)
+		} else if !strings.HasPrefix(err.Error(), "embed.mk:1:9: ") {
+			t.Errorf("[%s] wrong syntax error. got=%q", engine, err.Error())
+		}
+
+		_, err = r.Eval(context.Background(), "let f = fn() { 1 + true };\nf()")
+		var runtimeErr *Error
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("[%s] expected a runtime error, got=%v", engine, err)
+		}
+		if runtimeErr.Pos.String() != "embed.mk:1:18" || !strings.Contains(runtimeErr.Message, "MONKEY_BOOL") {
+			t.Errorf("[%s] wrong runtime error. got=%q", engine, runtimeErr.Error())
+		}
+		var stack []string
+		for _, frame := range runtimeErr.Stack {
+			stack = append(stack, frame.String())
+		}
+		expected := []string{"at f (embed.mk:1:18)", "at <main> (embed.mk:2:2)"}
+		if !reflect.DeepEqual(stack, expected) {
+			t.Errorf("[%s] wrong stack. Wanted=%q, got=%q", engine, expected, stack)
+		}
+
+		// the runtime stays usable after an error
+		result, err := r.Eval(context.Background(), "1")
+		if err != nil || result != int64(1) {
+			t.Errorf("[%s] runtime unusable after error. got=%#v (%v)", engine, result, err)
+		}
+
+		// the evaluator runs a program up to its error, the VM does not define the globals of
+		// a program that failed to compile
+		if _, err := r.Eval(context.Background(), "let a = 1; let b = nope;"); err == nil {
+			t.Errorf("[%s] expected an error for an undefined name", engine)
+		}
+		result, err = r.Eval(context.Background(), "a + 1")
+		if engine == EngineEval && (err != nil || result != int64(2)) {
+			t.Errorf("[%s] wrong result after failed program. got=%#v (%v)", engine, result, err)
+		}
+		if engine == EngineVM && (err == nil || !strings.HasSuffix(err.Error(), "Unknown symbol: a")) {
+			t.Errorf("[%s] expected a global of a failed program to be undefined. got=%#v (%v)", engine, result, err)
+		}
+
+		// a global whose let failed at runtime is known to the compiler but was never set
+		if _, err := r.Eval(context.Background(), "let c = 1 / 0;"); err == nil {
+			t.Errorf("[%s] expected a division by zero error", engine)
+		}
+		_, err = r.Eval(context.Background(), "c + 1")
+		if err == nil || err.Error() != "embed.mk:1:1: identifier not found: c" {
+			t.Errorf("[%s] wrong error reading the global of a failed let. got=%v", engine, err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := r.Eval(ctx, "1"); !errors.Is(err, context.Canceled) {
+			t.Errorf("[%s] expected context.Canceled, got=%v", engine, err)
+		}
+	}
+
+	if _, err := NewRuntime(Options{Engine: "jit"}); err == nil || err.Error() != `unknown engine "jit"` {
+		t.Errorf("wrong error for unknown engine. got=%v", err)
+	}
+}
+
+func TestUnsetVariables(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"if (false) { let x = 1; }; x", "embed.mk:1:28: identifier not found: x"},
+		{"let f = fn() { if (false) { let y = 1; }; y }; f()", "embed.mk:1:43: identifier not found: y"},
+		{"let f = fn() { if (false) { let y = 1; }; fn() { y } }; f()()", "embed.mk:1:50: identifier not found: y"},
+		{"try { let z = 1 / 0; } catch (e) { z }", "embed.mk:1:36: identifier not found: z"},
+	}
+
+	for _, engine := range engines {
+		for _, tt := range tests {
+			r := newRuntime(t, engine)
+			_, err := r.Eval(context.Background(), tt.input)
+			var runtimeErr *Error
+			if !errors.As(err, &runtimeErr) || err.Error() != tt.expected {
+				t.Errorf("[%s] %q: wrong error. Wanted=%q, got=%v", engine, tt.input, tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestFailingImports(t *testing.T) {
+	sandbox := &object.Sandbox{FS: fstest.MapFS{"fail.mk": {Data: []byte("let x = 1;\nlet y = x / 0;")}}}
+
+	for _, engine := range engines {
+		r, err := NewRuntime(Options{Engine: engine, Sandbox: sandbox, Filename: "main.mk"})
+		if err != nil {
+			t.Fatalf("could not create runtime: %s", err)
+		}
+		// a module whose run failed is run again by the next import
+		for i := 0; i < 2; i++ {
+			_, err := r.Eval(context.Background(), `import "fail.mk" as m; m["x"]`)
+			if err == nil || err.Error() != "fail.mk:2:11: division by zero" {
+				t.Errorf("[%s] import %d: wrong error. got=%v", engine, i+1, err)
+			}
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	registrations := []struct {
+		namespace string
+		name      string
+		fn        any
+	}{
+		{"strs", "repeat", strings.Repeat},
+		{"strs", "join", func(sep string, parts ...string) string { return strings.Join(parts, sep) }},
+		{"hostmath", "sum", func(xs []int) int {
+			total := 0
+			for _, x := range xs {
+				total += x
+			}
+			return total
+		}},
+		{"hostmath", "half", func(x float64) float64 { return x / 2 }},
+		{"hostmath", "mean", func(xs []float64) float64 {
+			total := 0.0
+			for _, x := range xs {
+				total += x
+			}
+			return total / float64(len(xs))
+		}},
+		{"hostmath", "small", func(x int8) int8 { return x }},
+		{"", "hostcheck", func(ok bool) (string, error) {
+			if !ok {
+				return "", errors.New("check failed")
+			}
+			return "ok", nil
+		}},
+		{"", "hostkeys", func(m map[string]any) int { return len(m) }},
+		{"", "hostinspect", func(obj object.Object) string { return obj.Inspect() }},
+	}
+	for _, reg := range registrations {
+		if err := Register(reg.namespace, reg.name, reg.fn); err != nil {
+			t.Fatalf("could not register %s: %s", reg.name, err)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected any
+		err      string
+	}{
+		{`strs["repeat"]("ab", 3)`, "ababab", ""},
+		{`strs["join"]("-", "a", "b", "c")`, "a-b-c", ""},
+		{`strs["join"](",")`, "", ""},
+		{`let sum = hostmath["sum"]; sum([1, 2, 3])`, int64(6), ""},
+		{`hostmath["half"](3.0)`, 1.5, ""},
+		{`hostcheck(true)`, "ok", ""},
+		{`hostkeys({"a": 1, "b": [2]})`, int64(2), ""},
+		{`hostinspect([1, true])`, "[1, true]", ""},
+		{`try { hostcheck(false) } catch (e) { e["message"] }`, "check failed", ""},
+		{`hostcheck(false)`, nil, "check failed"},
+		{`strs["repeat"]("ab")`, nil, "wrong number of arguments to `strs.repeat`. got=1, want=2"},
+		{`strs["join"]()`, nil, "wrong number of arguments to `strs.join`. got=0, want at least 1"},
+		{`strs["join"]("-", "a", 1)`, nil, "argument 3 to `strs.join` must be MONKEY_STRING, got MONKEY_INT"},
+		{`hostmath["half"](3)`, 1.5, ""},
+		{`hostmath["mean"]([1, 2.5, 3])`, 2.1666666666666665, ""},
+		{`hostmath["half"]("3")`, nil, "argument 1 to `hostmath.half` must be MONKEY_FLOAT, got MONKEY_STRING"},
+		{`hostmath["sum"]([1, "2"])`, nil, "argument 1 to `hostmath.sum`: element 1: cannot use MONKEY_STRING as int"},
+		{`hostmath["small"](300)`, nil, "argument 1 to `hostmath.small`: 300 overflows int8"},
+		{`hostmath["missing"]`, nil, `module hostmath has no member "missing"`},
+	}
+
+	for _, engine := range engines {
+		for _, tt := range tests {
+			r := newRuntime(t, engine)
+			result, err := r.Eval(context.Background(), tt.input)
+			if tt.err != "" {
+				var runtimeErr *Error
+				if !errors.As(err, &runtimeErr) || runtimeErr.Message != tt.err {
+					t.Errorf("[%s] %q: wrong error. Wanted=%q, got=%v", engine, tt.input, tt.err, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("[%s] %q: unexpected error: %s", engine, tt.input, err)
+				continue
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("[%s] %q: wrong result. Wanted=%#v, got=%#v", engine, tt.input, tt.expected, result)
+			}
+		}
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	tests := []struct {
+		namespace string
+		name      string
+		fn        any
+		expected  string
+	}{
+		{"", "notfn", 1, "cannot register int as builtin notfn, it is not a function"},
+		{"", "len", func() {}, "builtin len is already defined"},
+		{"len", "f", func() {}, "builtin len is already defined"},
+		{"", "two words", func() {}, `invalid builtin name "two words"`},
+		{"", "if", func() {}, `invalid builtin name "if"`},
+		{"a.b", "f", func() {}, `invalid builtin namespace "a.b"`},
+		{"", "chans", func(chan int) {}, "cannot register builtin chans: parameter 1: unsupported type chan int"},
+		{"", "keys", func(map[int]string) {}, "cannot register builtin keys: parameter 1: unsupported type map[int]string, map keys must be strings"},
+		{"", "results", func() (int, int) { return 0, 0 }, "cannot register builtin results: it must return at most a value and an error"},
+	}
+
+	for _, tt := range tests {
+		err := Register(tt.namespace, tt.name, tt.fn)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: wrong error. Wanted=%q, got=%v", tt.name, tt.expected, err)
+		}
+	}
+
+	if err := Register("dup", "f", func() {}); err != nil {
+		t.Fatalf("could not register dup.f: %s", err)
+	}
+	if err := Register("dup", "f", func() {}); err == nil || err.Error() != "builtin dup.f is already defined" {
+		t.Errorf("wrong error for duplicate namespaced builtin. got=%v", err)
+	}
+
+	// the bytecode refers to builtins by a one byte index
+	saved := object.Builtins
+	defer func() { object.Builtins = saved }()
+	for i := len(object.Builtins); i < object.MaxBuiltins; i++ {
+		name := "filler_" + strings.Repeat("x", i)
+		if err := Register("", name, func() {}); err != nil {
+			t.Fatalf("could not register %s: %s", name, err)
+		}
+	}
+	if err := Register("", "toomany", func() {}); err == nil || err.Error() != "cannot register builtin toomany, there are already 256 builtins" {
+		t.Errorf("wrong error for too many builtins. got=%v", err)
+	}
+	if err := Register("toomany", "f", func() {}); err == nil || err.Error() != "cannot register builtin namespace toomany, there are already 256 builtins" {
+		t.Errorf("wrong error for too many builtins. got=%v", err)
+	}
+	if err := Register("dup", "g", func() {}); err != nil {
+		t.Errorf("could not register into an existing namespace: %s", err)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	for _, engine := range engines {
+		r, err := NewRuntime(Options{Engine: engine, Budget: object.Budget{MaxSteps: 10000}})
+		if err != nil {
+			t.Fatalf("could not create runtime: %s", err)
+		}
+		_, err = r.Eval(context.Background(), "let spin = fn() { while (true) { } };")
+		if err != nil {
+			t.Fatalf("[%s] Eval failed: %s", engine, err)
+		}
+
+		_, err = r.Call("spin")
+		if !errors.Is(err, object.ErrBudgetExceeded) || !strings.Contains(err.Error(), "budget exceeded: more than 10000 steps") {
+			t.Errorf("[%s] expected the step budget to be exceeded, got=%v", engine, err)
+		}
+
+		// the budget applies to every call separately
+		result, err := r.Eval(context.Background(), "1 + 1")
+		if err != nil || result != int64(2) {
+			t.Errorf("[%s] wrong result after exceeded budget. got=%#v (%v)", engine, result, err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		r.opts.Budget = object.Budget{}
+		_, err = r.CallContext(ctx, "spin")
+		cancel()
+		if !errors.Is(err, object.ErrBudgetExceeded) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("[%s] expected the deadline to be exceeded, got=%v", engine, err)
+		}
+	}
+}
+
+func TestMemoryBudgetBeforeAllocation(t *testing.T) {
+	const size = 64 << 20
+	big := strings.Repeat("x", size)
+	elements := make([]int, 1<<20)
+	tests := []struct {
+		input string
+		size  uint64 // bytes the result would take, zero for values only counted by repeating them
+	}{
+		{"big + big", size},
+		{`"${big}${big}"`, size},
+		{"push(elements, 1)", 16 << 20},
+		{"tail(elements)", 16 << 20},
+		{`let s = "abc"; while (true) { s[0] }`, 0},
+		{`let e = try { 1 / 0 } catch (e) { e }; while (true) { e["stack"] }`, 0},
+	}
+
+	for _, engine := range engines {
+		// the steps only bound the loops in case their values are not counted
+		r, err := NewRuntime(Options{Engine: engine, Budget: object.Budget{MaxMemory: 1 << 20, MaxSteps: 1 << 22}})
+		if err != nil {
+			t.Fatalf("could not create runtime: %s", err)
+		}
+		// values set from Go are not counted, the values created from them are
+		if err := r.SetGlobal("big", big); err != nil {
+			t.Fatalf("[%s] SetGlobal failed: %s", engine, err)
+		}
+		if err := r.SetGlobal("elements", elements); err != nil {
+			t.Fatalf("[%s] SetGlobal failed: %s", engine, err)
+		}
+		for _, tt := range tests {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			_, err := r.Eval(context.Background(), tt.input)
+			runtime.ReadMemStats(&after)
+			if !errors.Is(err, object.ErrBudgetExceeded) || !strings.Contains(err.Error(), "bytes allocated") {
+				t.Errorf("[%s] %q: expected the memory budget to be exceeded, got=%v", engine, tt.input, err)
+			}
+			if allocated := after.TotalAlloc - before.TotalAlloc; tt.size > 0 && allocated >= tt.size {
+				t.Errorf("[%s] %q: %d bytes allocated before the budget was checked", engine, tt.input, allocated)
+			}
+		}
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	sandbox := &object.Sandbox{FS: fstest.MapFS{"config.txt": {Data: []byte("debug=true")}}}
+	tests := []struct {
+		input    string
+		expected any
+		err      string
+	}{
+		{`readfile("config.txt")`, "debug=true", ""},
+		{`readfile("../etc/passwd")`, nil, "error while trying to read ../etc/passwd: access denied: outside of the sandbox"},
+		{`writefile("config.txt", "")`, nil, "error while trying to write config.txt: access denied: read-only filesystem"},
+		{`try { readfile("missing.txt") } catch (e) { e["kind"] }`, "RuntimeError", ""},
+	}
+
+	for _, engine := range engines {
+		r, err := NewRuntime(Options{Engine: engine, Sandbox: sandbox})
+		if err != nil {
+			t.Fatalf("could not create runtime: %s", err)
+		}
+		for _, tt := range tests {
+			result, err := r.Eval(context.Background(), tt.input)
+			if tt.err != "" {
+				var runtimeErr *Error
+				if !errors.As(err, &runtimeErr) || runtimeErr.Message != tt.err {
+					t.Errorf("[%s] %q: wrong error. Wanted=%q, got=%v", engine, tt.input, tt.err, err)
+				}
+				continue
+			}
+			if err != nil || result != tt.expected {
+				t.Errorf("[%s] %q: wrong result. Wanted=%#v, got=%#v (%v)", engine, tt.input, tt.expected, result, err)
+			}
+		}
+
+		// builtins called from Go are sandboxed as well
+		if _, err := r.Call("readfile", "../etc/passwd"); err == nil || !strings.Contains(err.Error(), "access denied") {
+			t.Errorf("[%s] expected readfile to be denied, got=%v", engine, err)
+		}
+	}
+}
+
+func TestSandboxImports(t *testing.T) {
+	secretDir, allowedDir := t.TempDir(), t.TempDir()
+	secret := filepath.Join(secretDir, "lib.mk")
+	notMonkey := filepath.Join(secretDir, "token.txt")
+	for file, src := range map[string]string{
+		secret:                               `let token = "hunter2";`,
+		notMonkey:                            "hunter2",
+		filepath.Join(allowedDir, "util.mk"): "let answer = 42;",
+	} {
+		if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sandboxes := map[string]*object.Sandbox{
+		"fs":    {FS: fstest.MapFS{"util.mk": {Data: []byte("let answer = 42;")}}},
+		"roots": {Roots: []object.SandboxRoot{{Dir: allowedDir}}},
+	}
+	for sandboxName, sandbox := range sandboxes {
+		for _, engine := range engines {
+			filename := "main.mk"
+			if sandboxName == "roots" {
+				filename = filepath.Join(allowedDir, "main.mk")
+			}
+			r, err := NewRuntime(Options{Engine: engine, Sandbox: sandbox, Filename: filename})
+			if err != nil {
+				t.Fatalf("could not create runtime: %s", err)
+			}
+
+			result, err := r.Eval(context.Background(), `import "util.mk" as util; util["answer"]`)
+			if err != nil || result != int64(42) {
+				t.Errorf("[%s, %s] wrong result of sandboxed import. got=%#v (%v)", sandboxName, engine, result, err)
+			}
+			for _, file := range []string{secret, notMonkey} {
+				result, err := r.Eval(context.Background(), fmt.Sprintf("import %q as s; s", file))
+				if err == nil || strings.Contains(err.Error(), "hunter") {
+					t.Errorf("[%s, %s] expected import of %s to be denied. got=%#v (%v)", sandboxName, engine, file, result, err)
+				}
+			}
+		}
+	}
+}
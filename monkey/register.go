@@ -0,0 +1,205 @@
+package monkey
+
+import (
+	"fmt"
+	"monkey-int/object"
+	"reflect"
+)
+
+var (
+	objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Register adds the Go function fn to the builtins of both engines. Scripts call it as
+// namespace["name"](...), or as name(...) if namespace is empty.
+//
+// The parameters of fn can be integers, floats, strings, bools, slices and maps with string
+// keys of these, any or object.Object; arguments of other types are rejected before fn is
+// called, except for integers passed as floats. fn returns nothing, a value converted with FromGo, an error, or a value and an
+// error. A non-nil error is raised as a runtime error in the script.
+//
+// Functions have to be registered before the runtimes calling them are created. Together with
+// the predefined builtins, at most object.MaxBuiltins functions and namespaces can be registered.
+func Register(namespace, name string, fn any) error {
+	value := reflect.ValueOf(fn)
+	if value.Kind() != reflect.Func || value.IsNil() {
+		return fmt.Errorf("cannot register %T as builtin %s, it is not a function", fn, name)
+	}
+	fnType := value.Type()
+
+	params := make([]object.ObjectType, fnType.NumIn())
+	for i := range params {
+		paramType, err := objectTypeOf(parameterType(fnType, i))
+		if err != nil {
+			return fmt.Errorf("cannot register builtin %s: parameter %d: %w", name, i+1, err)
+		}
+		params[i] = paramType
+	}
+	switch {
+	case fnType.NumOut() > 2,
+		fnType.NumOut() == 2 && fnType.Out(1) != errorType:
+		return fmt.Errorf("cannot register builtin %s: it must return at most a value and an error", name)
+	}
+
+	native := object.NativeFunction{Namespace: namespace, Name: name, Params: params, Variadic: fnType.IsVariadic()}
+	qualifiedName := native.QualifiedName()
+	native.Fn = func(args ...object.Object) object.Object {
+		return callNative(qualifiedName, value, args)
+	}
+	return object.RegisterBuiltin(native)
+}
+
+// parameterType returns the type of the i-th argument of a call to a function of type fnType
+func parameterType(fnType reflect.Type, i int) reflect.Type {
+	if fnType.IsVariadic() && i >= fnType.NumIn()-1 {
+		return fnType.In(fnType.NumIn() - 1).Elem()
+	}
+	return fnType.In(i)
+}
+
+// objectTypeOf returns the type of the Monkey values that convert to t, empty if any value does
+func objectTypeOf(t reflect.Type) (object.ObjectType, error) {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return object.INTEGER_OBJ, nil
+	case reflect.Float32, reflect.Float64:
+		return object.FLOAT_OBJ, nil
+	case reflect.String:
+		return object.STRING_OBJ, nil
+	case reflect.Bool:
+		return object.BOOLEAN_OBJ, nil
+	case reflect.Slice:
+		if _, err := objectTypeOf(t.Elem()); err != nil {
+			return "", err
+		}
+		return object.ARRAY_OBJ, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return "", fmt.Errorf("unsupported type %s, map keys must be strings", t)
+		}
+		if _, err := objectTypeOf(t.Elem()); err != nil {
+			return "", err
+		}
+		return object.HASH_OBJ, nil
+	case reflect.Interface:
+		if t == objectType || t.NumMethod() == 0 {
+			return "", nil
+		}
+	}
+	return "", fmt.Errorf("unsupported type %s", t)
+}
+
+// callNative converts args to the parameter types of fn, calls it and converts its results back
+func callNative(name string, fn reflect.Value, args []object.Object) object.Object {
+	fnType := fn.Type()
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		value, err := toGoValue(arg, parameterType(fnType, i))
+		if err != nil {
+			return &object.Error{Message: fmt.Sprintf("argument %d to `%s`: %s", i+1, name, err)}
+		}
+		in[i] = value
+	}
+
+	out := fn.Call(in)
+	if len(out) > 0 && out[len(out)-1].Type() == errorType {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		out = out[:len(out)-1]
+	}
+	if len(out) == 0 {
+		return object.NULL
+	}
+	result, err := FromGo(out[0].Interface())
+	if err != nil {
+		return &object.Error{Message: fmt.Sprintf("result of `%s`: %s", name, err)}
+	}
+	return result
+}
+
+// toGoValue converts obj to a Go value of type t
+func toGoValue(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	value := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if integer, ok := obj.(*object.Integer); ok {
+			if value.OverflowInt(integer.Value) {
+				return value, fmt.Errorf("%d overflows %s", integer.Value, t)
+			}
+			value.SetInt(integer.Value)
+			return value, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if integer, ok := obj.(*object.Integer); ok {
+			if integer.Value < 0 || value.OverflowUint(uint64(integer.Value)) {
+				return value, fmt.Errorf("%d overflows %s", integer.Value, t)
+			}
+			value.SetUint(uint64(integer.Value))
+			return value, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch number := obj.(type) {
+		case *object.Float:
+			value.SetFloat(number.Value)
+			return value, nil
+		case *object.Integer:
+			value.SetFloat(float64(number.Value))
+			return value, nil
+		}
+	case reflect.String:
+		if str, ok := obj.(*object.String); ok {
+			value.SetString(str.Value)
+			return value, nil
+		}
+	case reflect.Bool:
+		if boolean, ok := obj.(*object.Boolean); ok {
+			value.SetBool(boolean.Value)
+			return value, nil
+		}
+	case reflect.Slice:
+		if array, ok := obj.(*object.Array); ok {
+			value = reflect.MakeSlice(t, len(array.Elements), len(array.Elements))
+			for i, element := range array.Elements {
+				converted, err := toGoValue(element, t.Elem())
+				if err != nil {
+					return value, fmt.Errorf("element %d: %w", i, err)
+				}
+				value.Index(i).Set(converted)
+			}
+			return value, nil
+		}
+	case reflect.Map:
+		if hash, ok := obj.(*object.Hash); ok {
+			value = reflect.MakeMapWithSize(t, len(hash.Pairs))
+			for _, pair := range hash.Pairs {
+				key, ok := pair.Key.(*object.String)
+				if !ok {
+					return value, fmt.Errorf("cannot use %s key as %s", pair.Key.Type(), t.Key())
+				}
+				converted, err := toGoValue(pair.Value, t.Elem())
+				if err != nil {
+					return value, fmt.Errorf("key %q: %w", key.Value, err)
+				}
+				value.SetMapIndex(reflect.ValueOf(key.Value).Convert(t.Key()), converted)
+			}
+			return value, nil
+		}
+	case reflect.Interface:
+		if t == objectType {
+			value.Set(reflect.ValueOf(obj))
+			return value, nil
+		}
+		converted, err := ToGo(obj)
+		if err != nil {
+			return value, err
+		}
+		if converted != nil {
+			value.Set(reflect.ValueOf(converted))
+		}
+		return value, nil
+	}
+	return value, fmt.Errorf("cannot use %s as %s", obj.Type(), t)
+}
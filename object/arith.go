@@ -0,0 +1,43 @@
+package object
+
+import "math"
+
+// Checked int64 arithmetic used by both engines when overflow checking is enabled.
+// The second result reports whether the operation stayed within the int64 range.
+
+func AddInt64(a, b int64) (int64, bool) {
+	c := a + b
+	return c, (c > a) == (b > 0)
+}
+
+func SubInt64(a, b int64) (int64, bool) {
+	c := a - b
+	return c, (c < a) == (b > 0)
+}
+
+func MulInt64(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	c := a * b
+	if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return c, false
+	}
+	return c, c/b == a
+}
+
+func DivInt64(a, b int64) (int64, bool) {
+	return a / b, !(a == math.MinInt64 && b == -1)
+}
+
+func NegInt64(a int64) (int64, bool) {
+	return -a, a != math.MinInt64
+}
+
+func ShlInt64(a, n int64) (int64, bool) {
+	if n >= 64 {
+		return 0, a == 0
+	}
+	c := a << n
+	return c, c>>n == a
+}
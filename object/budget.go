@@ -0,0 +1,152 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// Budget limits the resources a run of a script may use, zero fields are unlimited
+type Budget struct {
+	MaxSteps     int64         // instructions executed by the VM or nodes evaluated by the evaluator
+	MaxCallDepth int           // nested function calls, DefaultMaxCallDepth if zero
+	Timeout      time.Duration // wall-clock time
+
+	// MaxMemory limits the approximate bytes of the strings, arrays and hashes created
+	// during the run, memory freed by the garbage collector is not given back
+	MaxMemory int64
+}
+
+// DefaultMaxCallDepth bounds the recursion of runs whose budget sets no call depth. The Go
+// stack of the evaluator grows with the Monkey call stack, and overflowing it can't be recovered from.
+const DefaultMaxCallDepth = 1000
+
+// ErrBudgetExceeded is wrapped by the errors of runs that exceeded their budget or were cancelled
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// checkInterval is the number of steps between two checks of the context and the deadline
+const checkInterval = 1024
+
+// Meter tracks the resources used by a run of a script against its budget
+type Meter struct {
+	ctx      context.Context
+	budget   Budget
+	deadline time.Time
+	steps    int64
+	depth    int
+	memory   int64
+}
+
+// Start resets the meter for a run limited by budget that is aborted once ctx is done
+func (m *Meter) Start(ctx context.Context, budget Budget) error {
+	*m = Meter{ctx: ctx, budget: budget}
+	if budget.MaxCallDepth == 0 {
+		m.budget.MaxCallDepth = DefaultMaxCallDepth
+	}
+	if budget.Timeout > 0 {
+		m.deadline = time.Now().Add(budget.Timeout)
+	}
+	return m.check()
+}
+
+// Step counts one step of the run
+func (m *Meter) Step() error {
+	m.steps++
+	if m.budget.MaxSteps > 0 && m.steps > m.budget.MaxSteps {
+		return fmt.Errorf("%w: more than %d steps", ErrBudgetExceeded, m.budget.MaxSteps)
+	}
+	if m.steps%checkInterval == 0 {
+		return m.check()
+	}
+	return nil
+}
+
+// Enter counts a function call until the matching Leave
+func (m *Meter) Enter() error {
+	if m.depth >= m.budget.MaxCallDepth && m.budget.MaxCallDepth > 0 {
+		return fmt.Errorf("%w: call depth exceeds %d", ErrBudgetExceeded, m.budget.MaxCallDepth)
+	}
+	m.depth++
+	return nil
+}
+
+func (m *Meter) Leave() {
+	m.depth--
+}
+
+// Alloc counts memory allocated by the run
+func (m *Meter) Alloc(bytes int64) error {
+	m.memory += bytes
+	if m.budget.MaxMemory > 0 && m.memory > m.budget.MaxMemory {
+		return fmt.Errorf("%w: more than %d bytes allocated", ErrBudgetExceeded, m.budget.MaxMemory)
+	}
+	return nil
+}
+
+// approximate sizes of the values counted by Alloc on a 64-bit platform
+const (
+	stringHeaderSize = 16
+	arrayHeaderSize  = 32 // slice header and the Array struct
+	elementSize      = 16 // interface value
+	hashHeaderSize   = 56
+	HashPairSize     = 64 // key, pair and map overhead of one hash entry
+)
+
+// SizeOf returns the approximate size of a string, array or hash without the values it
+// refers to, which are counted when they are created. Other values are not counted.
+func SizeOf(obj Object) int64 {
+	switch obj := obj.(type) {
+	case *String:
+		return StringSize(len(obj.Value))
+	case *Array:
+		return ArraySize(len(obj.Elements))
+	case *Hash:
+		return HashSize(len(obj.Pairs))
+	}
+	return 0
+}
+
+// StringSize, ArraySize and HashSize return the approximate size of a string of length bytes,
+// an array of length elements and a hash of length pairs, so that the memory can be counted
+// before the value is created
+func StringSize(length int) int64 {
+	return stringHeaderSize + int64(length)
+}
+
+func ArraySize(length int) int64 {
+	return arrayHeaderSize + elementSize*int64(length)
+}
+
+func HashSize(length int) int64 {
+	return hashHeaderSize + HashPairSize*int64(length)
+}
+
+// IterationSize returns the approximate size of the elements IterableElements returns for
+// iterating over obj: a copy of the elements of an array, the keys of a hash or the characters
+// of a string, which are new strings
+func IterationSize(obj Object) int64 {
+	switch obj := obj.(type) {
+	case *Array:
+		return ArraySize(len(obj.Elements))
+	case *Hash:
+		return ArraySize(len(obj.Pairs))
+	case *String:
+		characters := utf8.RuneCountInString(obj.Value)
+		return ArraySize(characters) + StringSize(0)*int64(characters) + int64(len(obj.Value))
+	}
+	return 0
+}
+
+func (m *Meter) check() error {
+	if m.ctx != nil {
+		if err := m.ctx.Err(); err != nil {
+			return fmt.Errorf("%w: %w", ErrBudgetExceeded, err)
+		}
+	}
+	if !m.deadline.IsZero() && time.Now().After(m.deadline) {
+		return fmt.Errorf("%w: time limit of %s", ErrBudgetExceeded, m.budget.Timeout)
+	}
+	return nil
+}
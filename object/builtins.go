@@ -0,0 +1,202 @@
+package object
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Builtins is the ordered registry of builtin functions shared by the evaluator
+// and the compiler. The compiler refers to builtins by their index in this
+// slice, so new entries must only ever be appended.
+var Builtins = []BuiltinDefinition{
+	{
+		"len",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=%d", len(args), 1)
+			}
+			switch arg := args[0].(type) {
+			case *String:
+				return &Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			}
+			return newError("argument to `len` not supported, got %s", args[0].Type())
+		}},
+	},
+	{
+		"puts",
+		&Builtin{Fn: func(args ...Object) Object {
+			for _, arg := range args {
+				fmt.Println(arg.Inspect())
+			}
+			return nil
+		}},
+	},
+	{
+		"first",
+		&Builtin{ReturnsElement: true, Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=%d", len(args), 1)
+			}
+			val, ok := args[0].(*Array)
+			if !ok {
+				return newError("argument to `first` must be %s, got %s", ARRAY_OBJ, args[0].Type())
+			}
+			if len(val.Elements) <= 0 {
+				return nil
+			}
+			return val.Elements[0]
+		}},
+	},
+	{
+		"last",
+		&Builtin{ReturnsElement: true, Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=%d", len(args), 1)
+			}
+			val, ok := args[0].(*Array)
+			if !ok {
+				return newError("argument to `last` must be %s, got %s", ARRAY_OBJ, args[0].Type())
+			}
+			if len(val.Elements) <= 0 {
+				return nil
+			}
+			return val.Elements[len(val.Elements)-1]
+		}},
+	},
+	{
+		"tail",
+		&Builtin{ResultSize: arrayArgumentSize(-1), Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=%d", len(args), 1)
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return newError("argument to `tail` must be %s, got %s", ARRAY_OBJ, args[0].Type())
+			}
+			if len(arr.Elements) <= 0 {
+				return nil
+			}
+			newArray := make([]Object, len(arr.Elements)-1)
+			copy(newArray, arr.Elements[1:len(arr.Elements)])
+			return &Array{Elements: newArray}
+		}},
+	},
+	{
+		"push",
+		&Builtin{ResultSize: arrayArgumentSize(1), Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=%d", len(args), 2)
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return newError("argument to `push` must be %s, got %s", ARRAY_OBJ, args[0].Type())
+			}
+
+			newArray := make([]Object, len(arr.Elements)+1)
+			copy(newArray, arr.Elements)
+			newArray[len(arr.Elements)] = args[1]
+			return &Array{Elements: newArray}
+		}},
+	},
+	{
+		"readfile",
+		&Builtin{SandboxedFn: func(sandbox *Sandbox, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("readfile requires one argument")
+			}
+			filename, ok := args[0].(*String)
+			if !ok {
+				return newError("filename must be a string")
+			}
+			file, err := sandbox.ReadFile(filename.Value)
+			if err != nil {
+				return newError("error while trying to read %s: %s", filename.Value, err)
+			}
+			return &String{Value: string(file)}
+		}},
+	},
+	{
+		"writefile",
+		&Builtin{SandboxedFn: func(sandbox *Sandbox, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("writefile requires two arguments")
+			}
+			filename, ok := args[0].(*String)
+			if !ok {
+				return newError("filename must be a string")
+			}
+			content, ok := args[1].(*String)
+			if !ok {
+				return newError("content must be a string")
+			}
+			err := sandbox.WriteFile(filename.Value, []byte(content.Value))
+			if err != nil {
+				return newError("error while trying to write %s: %s", filename.Value, err)
+			}
+			return nil
+		}},
+	},
+	{
+		// throw(value) or throw(value, kind) raises an error, a caught error is raised again with its message and kind
+		"throw",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) < 1 || len(args) > 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+			err := &Error{Kind: ThrownErrorKind}
+			switch value := args[0].(type) {
+			case *ErrorValue:
+				err.Message, err.Kind = value.Message, value.Kind
+			case *String:
+				err.Message = value.Value
+			default:
+				err.Message = value.Inspect()
+			}
+			if len(args) == 2 {
+				kind, ok := args[1].(*String)
+				if !ok {
+					return newError("error kind must be %s, got %s", STRING_OBJ, args[1].Type())
+				}
+				err.Kind = kind.Value
+			}
+			return err
+		}},
+	},
+}
+
+// BuiltinDefinition is an entry of the Builtins registry, Value is a *Builtin or the *Module
+// holding the functions a host program registered under a namespace
+type BuiltinDefinition struct {
+	Name  string
+	Value Object
+}
+
+func GetBuiltinByName(name string) Object {
+	for _, def := range Builtins {
+		if def.Name == name {
+			return def.Value
+		}
+	}
+	return nil
+}
+
+// arrayArgumentSize returns the ResultSize of a builtin creating a copy of its array argument
+// with delta more elements
+func arrayArgumentSize(delta int) func(args ...Object) int64 {
+	return func(args ...Object) int64 {
+		if len(args) == 0 {
+			return 0
+		}
+		arr, ok := args[0].(*Array)
+		if !ok || len(arr.Elements)+delta < 0 {
+			return 0
+		}
+		return ArraySize(len(arr.Elements) + delta)
+	}
+}
+
+func newError(format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
+}
@@ -3,11 +3,52 @@ package object
 type Context struct {
 	store map[string]Object
 	outer *Context
+
+	// CheckedArithmetic makes integer overflow an error instead of wrapping around,
+	// enclosed contexts inherit the setting
+	CheckedArithmetic bool
+
+	// Sandbox restricts the files builtins and imports can access, nil allows any file. Enclosed
+	// contexts inherit the setting.
+	Sandbox *Sandbox
+
+	// Function is the stack trace name of the function call the context was created for,
+	// empty for the global context
+	Function string
+
+	// handler is set for the context of a catch handler, which only binds the catch parameter
+	// and defines the other names in the enclosing context
+	handler bool
+
+	modules *Modules // shared by the global contexts of a program and its modules
+	meter   *Meter   // shared by all contexts of a program and its modules
 }
 
 func NewContext() *Context {
 	s := make(map[string]Object)
-	return &Context{store: s, outer: nil}
+	return &Context{store: s, outer: nil, modules: NewModules(), meter: &Meter{}}
+}
+
+// NewModuleContext returns the global context a module imported from c is evaluated in,
+// it shares the settings and the module cache of c
+func (c *Context) NewModuleContext(function string) *Context {
+	ctx := NewContext()
+	ctx.CheckedArithmetic = c.CheckedArithmetic
+	ctx.Sandbox = c.Sandbox
+	ctx.Function = function
+	ctx.modules = c.modules
+	ctx.meter = c.meter
+	return ctx
+}
+
+// Meter returns the meter of the current run of the program
+func (c *Context) Meter() *Meter {
+	return c.meter
+}
+
+// Modules returns the cache of the modules imported by the program
+func (c *Context) Modules() *Modules {
+	return c.modules
 }
 
 func (c *Context) Get(name string) (Object, bool) {
@@ -19,12 +60,54 @@ func (c *Context) Get(name string) (Object, bool) {
 }
 
 func (c *Context) Set(name string, value Object) Object {
+	if _, ok := c.store[name]; !ok && c.handler {
+		return c.outer.Set(name, value)
+	}
 	c.store[name] = value
 	return value
 }
 
-func NewEnclosedContext(outer *Context) *Context {
-	ctx := NewContext()
-	ctx.outer = outer
+// Assign rebinds an existing name in the innermost context that defines it.
+// It reports false if the name is not defined in any enclosing context.
+func (c *Context) Assign(name string, value Object) (Object, bool) {
+	if _, ok := c.store[name]; ok {
+		c.store[name] = value
+		return value, true
+	}
+	if c.outer != nil {
+		return c.outer.Assign(name, value)
+	}
+	return nil, false
+}
+
+// FunctionName returns the name of the function call the context belongs to, or "<main>"
+// outside of any function
+func (c *Context) FunctionName() string {
+	for ctx := c; ctx != nil; ctx = ctx.outer {
+		if ctx.Function != "" {
+			return ctx.Function
+		}
+	}
+	return "<main>"
+}
+
+// NewHandlerContext returns the context the catch handler of a try expression is evaluated in,
+// with the catch parameter bound to err. Assigning the parameter does not affect a variable of
+// the same name in outer.
+func NewHandlerContext(outer *Context, parameter string, err Object) *Context {
+	ctx := NewEnclosedContext(outer)
+	ctx.handler = true
+	ctx.store[parameter] = err
 	return ctx
 }
+
+func NewEnclosedContext(outer *Context) *Context {
+	return &Context{
+		store:             make(map[string]Object),
+		outer:             outer,
+		CheckedArithmetic: outer.CheckedArithmetic,
+		Sandbox:           outer.Sandbox,
+		modules:           outer.modules,
+		meter:             outer.meter,
+	}
+}
@@ -0,0 +1,68 @@
+package object
+
+import "sort"
+
+// IterableElements returns the values a for loop visits when iterating over obj:
+// the elements of an array, the characters of a string or the keys of a hash.
+// Hash keys are sorted so that iteration order is deterministic.
+func IterableElements(obj Object) ([]Object, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		elements := make([]Object, len(obj.Elements))
+		copy(elements, obj.Elements)
+		return elements, true
+	case *String:
+		elements := []Object{}
+		for _, r := range obj.Value {
+			elements = append(elements, &String{Value: string(r)})
+		}
+		return elements, true
+	case *Hash:
+		keys := make([]Object, 0, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			keys = append(keys, pair.Key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return lessKey(keys[i], keys[j])
+		})
+		return keys, true
+	}
+	return nil, false
+}
+
+// RuneAt returns the character at index i of s, strings are indexed by code point like they
+// are iterated over
+func RuneAt(s string, i int64) (rune, bool) {
+	if i < 0 {
+		return 0, false
+	}
+	for _, r := range s {
+		if i == 0 {
+			return r, true
+		}
+		i--
+	}
+	return 0, false
+}
+
+func lessKey(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer:
+		if b, ok := b.(*Integer); ok {
+			return a.Value < b.Value
+		}
+	case *Float:
+		if b, ok := b.(*Float); ok {
+			return a.Value < b.Value
+		}
+	case *String:
+		if b, ok := b.(*String); ok {
+			return a.Value < b.Value
+		}
+	case *Boolean:
+		if b, ok := b.(*Boolean); ok {
+			return !a.Value && b.Value
+		}
+	}
+	return a.Type() < b.Type()
+}
@@ -0,0 +1,92 @@
+package object
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Module is the value of an imported module, it holds the values of the top-level
+// lets of the module file after the file has been evaluated
+type Module struct {
+	Name    string // import path of the module
+	Exports map[string]Object
+}
+
+func (m *Module) Type() ObjectType {
+	return MODULE_OBJ
+}
+
+func (m *Module) Inspect() string {
+	return fmt.Sprintf("<module %s>", m.Name)
+}
+
+// Member returns the export of the module named by index
+func (m *Module) Member(index Object) (Object, error) {
+	if name, ok := index.(*String); ok {
+		if value, ok := m.Exports[name.Value]; ok {
+			return value, nil
+		}
+		return nil, fmt.Errorf("module %s has no member %q", m.Name, name.Value)
+	}
+	return nil, fmt.Errorf("module member must be %s, got %s", STRING_OBJ, index.Type())
+}
+
+// ModuleFunctionName is the name a module is shown with in stack traces
+func ModuleFunctionName(name string) string {
+	return "<module " + name + ">"
+}
+
+// Modules caches the modules of a program by file, so every module is evaluated only once
+type Modules struct {
+	loaded  map[string]*Module
+	loading []string // file of the main program and of the modules being evaluated, outermost first
+}
+
+func NewModules() *Modules {
+	return &Modules{loaded: map[string]*Module{}}
+}
+
+func (m *Modules) Get(file string) (*Module, bool) {
+	module, ok := m.loaded[file]
+	return module, ok
+}
+
+// Begin marks file, imported from the file importer, as being evaluated. It fails if the file
+// is already being evaluated, which means the module imports itself through a cycle of imports.
+func (m *Modules) Begin(importer, file string) error {
+	if len(m.loading) == 0 {
+		m.loading = []string{filepath.Clean(importer)}
+	}
+	if err := ImportCycle(m.loading, file); err != nil {
+		if len(m.loading) == 1 {
+			m.loading = nil
+		}
+		return err
+	}
+	m.loading = append(m.loading, file)
+	return nil
+}
+
+// End marks the file passed to the last Begin as evaluated, module is nil if evaluation failed
+func (m *Modules) End(module *Module) {
+	file := m.loading[len(m.loading)-1]
+	m.loading = m.loading[:len(m.loading)-1]
+	if len(m.loading) == 1 {
+		m.loading = nil // only the main program is left
+	}
+	if module != nil {
+		m.loaded[file] = module
+	}
+}
+
+// ImportCycle returns an error naming the cycle if file is one of the files being imported
+func ImportCycle(importing []string, file string) error {
+	for i, f := range importing {
+		if f == file {
+			cycle := append(append([]string{}, importing[i:]...), file)
+			return fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package object
+
+import (
+	"fmt"
+	"monkey-int/token"
+	"unicode"
+)
+
+// NativeFunction is a Go function a host program adds to the builtins with RegisterBuiltin
+type NativeFunction struct {
+	// Namespace groups the function with others, it is called as namespace["name"](...).
+	// Functions without a namespace are called by name like the predefined builtins.
+	Namespace string
+	Name      string
+
+	// Params are the types of the parameters, an empty type accepts any value. Float
+	// parameters accept integers as well, as arithmetic mixing both does.
+	Params []ObjectType
+	// Variadic makes the last parameter accept any number of arguments, including none
+	Variadic bool
+
+	Fn BuiltinFunction // only called with arguments matching Params
+}
+
+// QualifiedName is the name the function is reported with in errors
+func (f *NativeFunction) QualifiedName() string {
+	if f.Namespace == "" {
+		return f.Name
+	}
+	return f.Namespace + "." + f.Name
+}
+
+// MaxBuiltins is the number of builtins and namespaces the bytecode can refer to, the
+// functions of a namespace are not counted
+const MaxBuiltins = 256
+
+// RegisterBuiltin makes a native function available to both engines. Scripts only see the
+// functions registered before they are compiled or evaluated, and registering while scripts
+// are running is not safe.
+func RegisterBuiltin(f NativeFunction) error {
+	if !IsIdentifier(f.Name) {
+		return fmt.Errorf("invalid builtin name %q", f.Name)
+	}
+	if f.Variadic && len(f.Params) == 0 {
+		return fmt.Errorf("variadic builtin %s needs at least one parameter", f.QualifiedName())
+	}
+	if f.Fn == nil {
+		return fmt.Errorf("builtin %s has no function", f.QualifiedName())
+	}
+	builtin := &Builtin{Fn: checkArguments(f)}
+
+	if f.Namespace == "" {
+		if GetBuiltinByName(f.Name) != nil {
+			return fmt.Errorf("builtin %s is already defined", f.Name)
+		}
+		if len(Builtins) >= MaxBuiltins {
+			return fmt.Errorf("cannot register builtin %s, there are already %d builtins", f.Name, MaxBuiltins)
+		}
+		Builtins = append(Builtins, BuiltinDefinition{Name: f.Name, Value: builtin})
+		return nil
+	}
+
+	if !IsIdentifier(f.Namespace) {
+		return fmt.Errorf("invalid builtin namespace %q", f.Namespace)
+	}
+	namespace, ok := GetBuiltinByName(f.Namespace).(*Module)
+	if !ok {
+		if GetBuiltinByName(f.Namespace) != nil {
+			return fmt.Errorf("builtin %s is already defined", f.Namespace)
+		}
+		if len(Builtins) >= MaxBuiltins {
+			return fmt.Errorf("cannot register builtin namespace %s, there are already %d builtins", f.Namespace, MaxBuiltins)
+		}
+		namespace = &Module{Name: f.Namespace, Exports: map[string]Object{}}
+		Builtins = append(Builtins, BuiltinDefinition{Name: f.Namespace, Value: namespace})
+	}
+	if _, ok := namespace.Exports[f.Name]; ok {
+		return fmt.Errorf("builtin %s is already defined", f.QualifiedName())
+	}
+	namespace.Exports[f.Name] = builtin
+	return nil
+}
+
+// checkArguments wraps the function of f with the arity and type checks f declares
+func checkArguments(f NativeFunction) BuiltinFunction {
+	name := f.QualifiedName()
+	return func(args ...Object) Object {
+		if f.Variadic {
+			if len(args) < len(f.Params)-1 {
+				return newError("wrong number of arguments to `%s`. got=%d, want at least %d", name, len(args), len(f.Params)-1)
+			}
+		} else if len(args) != len(f.Params) {
+			return newError("wrong number of arguments to `%s`. got=%d, want=%d", name, len(args), len(f.Params))
+		}
+		for i, arg := range args {
+			expected := f.Params[min(i, len(f.Params)-1)]
+			if expected == FLOAT_OBJ && arg.Type() == INTEGER_OBJ {
+				continue
+			}
+			if expected != "" && arg.Type() != expected {
+				return newError("argument %d to `%s` must be %s, got %s", i+1, name, expected, arg.Type())
+			}
+		}
+		return f.Fn(args...)
+	}
+}
+
+// IsIdentifier reports whether scripts can refer to name, which the lexer reads as an identifier
+func IsIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, ch := range name {
+		if !unicode.IsLetter(ch) && ch != '_' {
+			return false
+		}
+	}
+	var tok token.Token
+	return tok.LookupIdent(name) == token.IDENTIFIER
+}
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"monkey-int/ast"
 	"monkey-int/bytecode"
+	"monkey-int/token"
+	"strconv"
 	"strings"
 )
 
@@ -13,6 +16,7 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ           = "MONKEY_INT"
+	FLOAT_OBJ             = "MONKEY_FLOAT"
 	BOOLEAN_OBJ           = "MONKEY_BOOL"
 	NULL_OBJ              = "MONKEY_NULL"
 	RETURN_VALUE_OBJ      = "MONKEY_RETURN"
@@ -23,6 +27,21 @@ const (
 	ARRAY_OBJ             = "MONKEY_ARRAY"
 	HASH_OBJ              = "MONKEY_HASH"
 	COMPILED_FUNCTION_OBJ = "MONKEY_COMPILED_FUNC"
+	CLOSURE_OBJ           = "MONKEY_CLOSURE"
+	BREAK_OBJ             = "MONKEY_BREAK"
+	CONTINUE_OBJ          = "MONKEY_CONTINUE"
+	ERROR_VALUE_OBJ       = "MONKEY_ERROR_VALUE"
+	MODULE_OBJ            = "MONKEY_MODULE"
+	CELL_OBJ              = "MONKEY_CELL"
+)
+
+// error kinds, user code can throw errors of any other kind as well
+const (
+	RuntimeErrorKind  = "RuntimeError"  // raised by the interpreter, e.g. division by zero
+	InternalErrorKind = "InternalError" // a bug in the interpreter itself, can't be caught
+	ThrownErrorKind   = "Error"         // default kind of errors raised with throw
+
+	BudgetExceededKind = "BudgetExceeded" // the run exceeded its Budget, can't be caught
 )
 
 type Object interface {
@@ -42,10 +61,34 @@ func (i *Integer) Inspect() string {
 	return fmt.Sprintf("%d", i.Value)
 }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType {
+	return FLOAT_OBJ
+}
+
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		// keep floats distinguishable from integers, e.g. 2.0 instead of 2
+		s += ".0"
+	}
+	return s
+}
+
 type Boolean struct {
 	Value bool
 }
 
+// the booleans and null are singletons shared by both engines, which compare them by identity
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+	NULL  = &Null{}
+)
+
 func (b *Boolean) Type() ObjectType {
 	return BOOLEAN_OBJ
 }
@@ -76,8 +119,33 @@ func (rv *ReturnValue) Inspect() string {
 	return rv.Value.Inspect()
 }
 
+// Break and Continue signal loop control flow in the evaluator, like ReturnValue
+// they never escape the statement they belong to
+type Break struct{}
+
+func (b *Break) Type() ObjectType {
+	return BREAK_OBJ
+}
+
+func (b *Break) Inspect() string {
+	return "break"
+}
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType {
+	return CONTINUE_OBJ
+}
+
+func (c *Continue) Inspect() string {
+	return "continue"
+}
+
 type Error struct {
 	Message string
+	Kind    string         // defaults to RuntimeErrorKind if empty
+	Pos     token.Position // where the error was raised, if known
+	Stack   []StackFrame   // Monkey call stack, innermost first, if recorded
 }
 
 func (e *Error) Type() ObjectType {
@@ -85,10 +153,134 @@ func (e *Error) Type() ObjectType {
 }
 
 func (e *Error) Inspect() string {
-	return "ERROR: " + e.Message
+	return "ERROR: " + e.Describe()
+}
+
+// Describe returns the error message prefixed with its source position, if known
+func (e *Error) Describe() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Message
+	}
+	return e.Message
+}
+
+// ErrorKind returns the kind of the error
+func (e *Error) ErrorKind() string {
+	if e.Kind == "" {
+		return RuntimeErrorKind
+	}
+	return e.Kind
+}
+
+// ErrorValue is an error caught by a try expression. Unlike Error, which is propagated
+// until it is caught, it is an ordinary value that can be stored and passed around.
+type ErrorValue struct {
+	Message string
+	Kind    string
+	Stack   []StackFrame
+}
+
+func (ev *ErrorValue) Type() ObjectType {
+	return ERROR_VALUE_OBJ
+}
+
+func (ev *ErrorValue) Inspect() string {
+	return ev.Kind + ": " + ev.Message
+}
+
+// Field returns the message, kind or stack field of the error, it reports false for any other index
+func (ev *ErrorValue) Field(index Object) (Object, bool) {
+	name, ok := index.(*String)
+	if !ok {
+		return nil, false
+	}
+	switch name.Value {
+	case "message":
+		return &String{Value: ev.Message}, true
+	case "kind":
+		return &String{Value: ev.Kind}, true
+	case "stack":
+		frames := make([]Object, len(ev.Stack))
+		for i, frame := range ev.Stack {
+			frames[i] = &String{Value: frame.String()}
+		}
+		return &Array{Elements: frames}, true
+	}
+	return nil, false
+}
+
+// FieldSize returns the approximate size of the value Field creates for index
+func (ev *ErrorValue) FieldSize(index Object) int64 {
+	name, ok := index.(*String)
+	if !ok {
+		return 0
+	}
+	switch name.Value {
+	case "message":
+		return StringSize(len(ev.Message))
+	case "kind":
+		return StringSize(len(ev.Kind))
+	case "stack":
+		size := ArraySize(len(ev.Stack))
+		for _, frame := range ev.Stack {
+			size += StringSize(len(frame.String()))
+		}
+		return size
+	}
+	return 0
+}
+
+// Caught turns an error that has been caught into a value, stack is the call stack
+// from where the error was raised up to where it was caught
+func (e *Error) Caught(stack []StackFrame) *ErrorValue {
+	return &ErrorValue{Message: e.Message, Kind: e.ErrorKind(), Stack: stack}
+}
+
+// StackFrame is one entry of a Monkey call stack
+type StackFrame struct {
+	Function string         // function name, "<anonymous>" or "<main>"
+	Pos      token.Position // position reached within the function
+}
+
+func (f StackFrame) String() string {
+	if f.Pos.IsValid() {
+		return "at " + f.Function + " (" + f.Pos.String() + ")"
+	}
+	return "at " + f.Function
+}
+
+// FormatStack renders a call stack with one indented frame per line. Runs of more than two
+// identical frames, like the ones of a recursion exceeding the call depth, are rendered as
+// their first frame followed by the number of repetitions.
+func FormatStack(stack []StackFrame) string {
+	var out bytes.Buffer
+	for i := 0; i < len(stack); {
+		run := 1
+		for i+run < len(stack) && stack[i+run] == stack[i] {
+			run++
+		}
+		out.WriteString("\t" + stack[i].String() + "\n")
+		switch {
+		case run > 2:
+			fmt.Fprintf(&out, "\t... repeated %d more times\n", run-1)
+		case run == 2:
+			out.WriteString("\t" + stack[i].String() + "\n")
+		}
+		i += run
+	}
+	return out.String()
+}
+
+// FunctionName returns the name used for a function in stack traces
+func FunctionName(name string) string {
+	if name == "" {
+		return "<anonymous>"
+	}
+	return name
 }
 
 type Function struct {
+	Name       string // name of the let binding the literal was assigned to, if any
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Ctx        *Context
@@ -129,6 +321,39 @@ type BuiltinFunction func(args ...Object) Object
 
 type Builtin struct {
 	Fn BuiltinFunction
+
+	// SandboxedFn is called instead of Fn by builtins accessing files, with the sandbox of the run
+	SandboxedFn func(sandbox *Sandbox, args ...Object) Object
+
+	// ReturnsElement is set for builtins returning an element of an argument instead of a new value
+	ReturnsElement bool
+
+	// ResultSize returns the approximate size of the value a call with args creates, which is
+	// counted against the memory budget before the call
+	ResultSize func(args ...Object) int64
+}
+
+// Call calls the builtin within a run whose file access is restricted by sandbox
+func (b *Builtin) Call(sandbox *Sandbox, args ...Object) Object {
+	if b.SandboxedFn != nil {
+		return b.SandboxedFn(sandbox, args...)
+	}
+	return b.Fn(args...)
+}
+
+// Created reports whether the result of calling the builtin with args is a value it created,
+// which counts against the memory budget after the call, rather than an argument or one of its
+// elements or a value counted before the call with ResultSize
+func (b *Builtin) Created(result Object, args []Object) bool {
+	if b.ReturnsElement || b.ResultSize != nil {
+		return false
+	}
+	for _, arg := range args {
+		if result == arg {
+			return false
+		}
+	}
+	return true
 }
 
 func (b *Builtin) Type() ObjectType {
@@ -148,14 +373,8 @@ func (ao *Array) Type() ObjectType {
 }
 
 func (ao *Array) Inspect() string {
-	var out bytes.Buffer
-	elements := []string{}
-	for _, e := range ao.Elements {
-		elements = append(elements, e.Inspect())
-	}
-	out.WriteString("[")
-	out.WriteString(strings.Join(elements, ", "))
-	out.WriteString("]")
+	var out strings.Builder
+	writeInspect(&out, ao, map[Object]bool{}, 0)
 	return out.String()
 }
 
@@ -176,6 +395,17 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// HashKey of a whole-valued float is the key of the equal integer, as 1.0 == 1 the
+// hash literal {1.0: "a"} can be indexed with 1 and {1: "a"} with 1.0
+func (f *Float) HashKey() HashKey {
+	value := f.Value
+	if value == math.Trunc(value) && value >= math.MinInt64 && value < math.MaxInt64 {
+		// also makes -0.0 and 0.0 the same key
+		return (&Integer{Value: int64(value)}).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(value)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -196,24 +426,75 @@ func (h *Hash) Type() ObjectType {
 }
 
 func (h *Hash) Inspect() string {
-	var out bytes.Buffer
-	pairs := []string{}
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
-	}
-
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
+	var out strings.Builder
+	writeInspect(&out, h, map[Object]bool{}, 0)
 	return out.String()
 }
 
+// maxInspectDepth is the nesting depth up to which arrays and hashes are printed, deeper
+// ones are printed as [...] and {...} like arrays and hashes that contain themselves
+const maxInspectDepth = 1000
+
+// writeInspect prints an element of an array or hash, passing on the containers being printed
+func writeInspect(out *strings.Builder, obj Object, seen map[Object]bool, depth int) {
+	switch obj := obj.(type) {
+	case *Array:
+		if seen[obj] || depth >= maxInspectDepth {
+			out.WriteString("[...]")
+			return
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+
+		out.WriteString("[")
+		for i, e := range obj.Elements {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			writeInspect(out, e, seen, depth+1)
+		}
+		out.WriteString("]")
+	case *Hash:
+		if seen[obj] || depth >= maxInspectDepth {
+			out.WriteString("{...}")
+			return
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+
+		out.WriteString("{")
+		i := 0
+		for _, pair := range obj.Pairs {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			out.WriteString(pair.Key.Inspect())
+			out.WriteString(": ")
+			writeInspect(out, pair.Value, seen, depth+1)
+			i++
+		}
+		out.WriteString("}")
+	default:
+		out.WriteString(obj.Inspect())
+	}
+}
+
 type Hashable interface {
 	HashKey() HashKey
 }
 
 type CompiledFunction struct {
-	Instructions bytecode.Instructions
+	Name          string
+	Instructions  bytecode.Instructions
+	NumLocals     int
+	NumParameters int
+	SourceMap     bytecode.SourceMap
+	Handlers      bytecode.ExceptionTable // try expressions within the function
+
+	// names of the local and free variables by index, reported when a variable is read
+	// before its let has run
+	LocalNames []string
+	FreeNames  []string
 }
 
 func (cf *CompiledFunction) Type() ObjectType {
@@ -223,3 +504,30 @@ func (cf *CompiledFunction) Type() ObjectType {
 func (cf *CompiledFunction) Inspect() string {
 	return fmt.Sprintf("CompiledFunction[%p]", cf)
 }
+
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []*Cell
+}
+
+func (c *Closure) Type() ObjectType {
+	return CLOSURE_OBJ
+}
+
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// Cell holds a local variable captured by closures. The VM stores it in the slot of the
+// variable, so that the function defining the variable and its closures share its value.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType {
+	return CELL_OBJ
+}
+
+func (c *Cell) Inspect() string {
+	return c.Value.Inspect()
+}
@@ -1,6 +1,15 @@
 package object
 
-import "testing"
+import (
+	"errors"
+	"math"
+	"monkey-int/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -17,3 +26,230 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestFloatHashKey(t *testing.T) {
+	one1 := &Float{Value: 1.5}
+	one2 := &Float{Value: 1.5}
+	zero := &Float{Value: 0}
+	negZero := &Float{Value: -zero.Value}
+	if one1.HashKey() != one2.HashKey() {
+		t.Errorf("floats with same value have different hash keys")
+	}
+	if one1.HashKey() == zero.HashKey() {
+		t.Errorf("floats with different values have same hash keys")
+	}
+	if zero.HashKey() != negZero.HashKey() {
+		t.Errorf("0.0 and -0.0 have different hash keys")
+	}
+	if (&Integer{Value: 1}).HashKey() != (&Float{Value: 1}).HashKey() {
+		t.Errorf("integer and equal whole-valued float have different hash keys")
+	}
+	if (&Integer{Value: 1}).HashKey() == one1.HashKey() {
+		t.Errorf("integer and fractional float have same hash keys")
+	}
+	if (&Float{Value: math.Inf(1)}).HashKey() == (&Float{Value: math.MaxInt64}).HashKey() {
+		t.Errorf("infinity and large float have same hash keys")
+	}
+}
+
+func TestFormatStack(t *testing.T) {
+	f := StackFrame{Function: "f", Pos: token.Position{File: "a.mk", Line: 1, Column: 20}}
+	g := StackFrame{Function: "g", Pos: token.Position{File: "a.mk", Line: 2, Column: 5}}
+	main := StackFrame{Function: "<main>", Pos: token.Position{File: "a.mk", Line: 3, Column: 2}}
+
+	stack := []StackFrame{g, g}
+	for i := 0; i < 999; i++ {
+		stack = append(stack, f)
+	}
+	stack = append(stack, main)
+
+	expected := "\tat g (a.mk:2:5)\n" +
+		"\tat g (a.mk:2:5)\n" +
+		"\tat f (a.mk:1:20)\n" +
+		"\t... repeated 998 more times\n" +
+		"\tat <main> (a.mk:3:2)\n"
+	if formatted := FormatStack(stack); formatted != expected {
+		t.Errorf("wrong stack. Wanted=%q, got=%q", expected, formatted)
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{1.5, "1.5"},
+		{2, "2.0"},
+		{-3, "-3.0"},
+		{1e21, "1e+21"},
+		{0.001, "0.001"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("Wrong Inspect output. Wanted=%q, got=%q instead.", tt.expected, f.Inspect())
+		}
+	}
+}
+
+// writableFS is a virtual filesystem files can be written to
+type writableFS struct {
+	fstest.MapFS
+}
+
+func (w writableFS) WriteFile(name string, data []byte) error {
+	w.MapFS[name] = &fstest.MapFile{Data: data}
+	return nil
+}
+
+func TestNestedInspect(t *testing.T) {
+	self := &Array{}
+	self.Elements = []Object{&Integer{Value: 1}, self}
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	key := &String{Value: "h"}
+	hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: hash}
+
+	tests := []struct {
+		obj      Object
+		expected string
+	}{
+		{&Array{Elements: []Object{&Integer{Value: 1}, &Array{}, &Integer{Value: 2}}}, "[1, [], 2]"},
+		{self, "[1, [...]]"},
+		{hash, "{h: {...}}"},
+		{&Array{Elements: []Object{hash}}, "[{h: {...}}]"},
+	}
+	for _, tt := range tests {
+		if got := tt.obj.Inspect(); got != tt.expected {
+			t.Errorf("wrong output. Wanted=%q, got=%q instead.", tt.expected, got)
+		}
+	}
+
+	// nesting deeper than maxInspectDepth is cut off instead of exhausting the Go stack
+	var deep Object = &Array{}
+	for i := 0; i < 1000000; i++ {
+		deep = &Array{Elements: []Object{deep}}
+	}
+	expected := strings.Repeat("[", maxInspectDepth) + "[...]" + strings.Repeat("]", maxInspectDepth)
+	if got := deep.Inspect(); got != expected {
+		t.Errorf("wrong output for deeply nested array, got %d bytes", len(got))
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"ro/a.txt": "a", "rw/b.txt": "b", "outside/secret.txt": "secret"}
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "outside/secret.txt"), filepath.Join(dir, "rw/link")); err != nil {
+		t.Skipf("symlinks not supported: %s", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "outside/new.txt"), filepath.Join(dir, "rw/dangling")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "ro"), filepath.Join(dir, "rw/ro")); err != nil {
+		t.Fatal(err)
+	}
+
+	sandbox := &Sandbox{Roots: []SandboxRoot{
+		{Dir: filepath.Join(dir, "ro")},
+		{Dir: filepath.Join(dir, "rw"), Writable: true},
+	}}
+	virtual := &Sandbox{FS: fstest.MapFS{"data/a.txt": {Data: []byte("virtual")}}}
+	writable := &Sandbox{FS: writableFS{fstest.MapFS{}}}
+
+	tests := []struct {
+		sandbox  *Sandbox
+		write    bool
+		name     string
+		expected string // content read, or the error
+	}{
+		{sandbox, false, "ro/a.txt", "a"},
+		{sandbox, false, "rw/b.txt", "b"},
+		{sandbox, true, "rw/new.txt", ""},
+		{sandbox, false, "rw/new.txt", "new"},
+		{sandbox, true, "ro/new.txt", "access denied: read-only directory"},
+		{sandbox, true, "rw/ro/new.txt", "access denied: read-only directory"},
+		{sandbox, false, "rw/ro/a.txt", "a"},
+		{sandbox, false, "outside/secret.txt", "access denied: outside of the sandbox"},
+		{sandbox, false, "outside/missing.txt", "access denied: outside of the sandbox"},
+		{sandbox, false, "rw/../outside/secret.txt", "access denied: outside of the sandbox"},
+		{sandbox, false, "rw/link", "access denied: outside of the sandbox"},
+		{sandbox, true, "rw/link", "access denied: outside of the sandbox"},
+		{sandbox, true, "rw/dangling", "access denied: dangling symlink"},
+		{&Sandbox{}, false, "ro/a.txt", "access denied: file access is disabled"},
+		{nil, false, "outside/secret.txt", "secret"},
+		{virtual, false, "data/a.txt", "virtual"},
+		{virtual, false, "/data/a.txt", "virtual"},
+		{virtual, false, "data/../../a.txt", "access denied: outside of the sandbox"},
+		{virtual, true, "data/a.txt", "access denied: read-only filesystem"},
+		{writable, true, "new.txt", ""},
+		{writable, false, "new.txt", "new"},
+	}
+
+	for _, tt := range tests {
+		name := tt.name
+		if tt.sandbox == nil || tt.sandbox.FS == nil {
+			name = filepath.Join(dir, tt.name)
+		}
+		var result string
+		var err error
+		if tt.write {
+			err = tt.sandbox.WriteFile(name, []byte("new"))
+		} else {
+			var content []byte
+			content, err = tt.sandbox.ReadFile(name)
+			result = string(content)
+		}
+		if err != nil {
+			result = err.Error()
+			if !errors.Is(err, ErrFileAccessDenied) {
+				t.Errorf("%s: error does not wrap ErrFileAccessDenied: %s", tt.name, err)
+			}
+		}
+		if result != tt.expected {
+			t.Errorf("%s: wrong result. Wanted=%q, got=%q", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestSandboxSymlinkSwappedAfterCheck(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"rw/sub/a.txt": "a", "outside/a.txt": "secret"} {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sandbox := &Sandbox{Roots: []SandboxRoot{{Dir: filepath.Join(dir, "rw"), Writable: true}}}
+
+	root, name, err := sandbox.resolve(filepath.Join(dir, "rw/sub/a.txt"), false)
+	if err != nil {
+		t.Fatalf("resolve failed: %s", err)
+	}
+	defer root.Close()
+
+	// the directory is replaced by a symlink leaving the root between the check and the access
+	if err := os.RemoveAll(filepath.Join(dir, "rw/sub")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "outside"), filepath.Join(dir, "rw/sub")); err != nil {
+		t.Skipf("symlinks not supported: %s", err)
+	}
+	if content, err := root.ReadFile(name); err == nil {
+		t.Errorf("read %q through a symlink leaving the sandbox", content)
+	}
+	if err := root.WriteFile(name, []byte("new"), 0666); err == nil {
+		t.Errorf("wrote through a symlink leaving the sandbox")
+	}
+}
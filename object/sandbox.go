@@ -0,0 +1,177 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Sandbox restricts the files the readfile and writefile builtins can access and the modules
+// scripts can import. A nil *Sandbox allows access to any file, an empty Sandbox disables file
+// access entirely.
+type Sandbox struct {
+	// Roots are the directories files can be accessed in, including their subdirectories
+	Roots []SandboxRoot
+
+	// FS replaces the OS filesystem and Roots if set, paths are resolved relative to its root.
+	// Files can only be written if it implements WriteFileFS.
+	FS fs.FS
+}
+
+type SandboxRoot struct {
+	Dir      string
+	Writable bool // files can be written as well as read
+}
+
+// WriteFileFS is a virtual filesystem files can be written to
+type WriteFileFS interface {
+	fs.FS
+	WriteFile(name string, data []byte) error
+}
+
+// ErrFileAccessDenied is wrapped by the errors of file accesses the sandbox does not allow
+var ErrFileAccessDenied = errors.New("access denied")
+
+func (s *Sandbox) ReadFile(name string) ([]byte, error) {
+	if s == nil {
+		return os.ReadFile(name)
+	}
+	if s.FS != nil {
+		fsName, err := fsPath(name)
+		if err != nil {
+			return nil, err
+		}
+		return fs.ReadFile(s.FS, fsName)
+	}
+	root, realName, err := s.resolve(name, false)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return root.ReadFile(realName)
+}
+
+func (s *Sandbox) WriteFile(name string, data []byte) error {
+	if s == nil {
+		return os.WriteFile(name, data, 0666)
+	}
+	if s.FS != nil {
+		fsName, err := fsPath(name)
+		if err != nil {
+			return err
+		}
+		writable, ok := s.FS.(WriteFileFS)
+		if !ok {
+			return fmt.Errorf("%w: read-only filesystem", ErrFileAccessDenied)
+		}
+		return writable.WriteFile(fsName, data)
+	}
+	root, realName, err := s.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	return root.WriteFile(realName, data, 0666)
+}
+
+// resolve follows the symlinks of the file name and returns the root that contains the
+// resolved path and allows the access, opened as an *os.Root, and the path relative to it.
+// It fails unless both name and the resolved path lie within such a root. The file has to
+// be accessed through the returned root, which keeps symlinks swapped in after the check
+// from leading out of it.
+func (s *Sandbox) resolve(name string, write bool) (*os.Root, string, error) {
+	if len(s.Roots) == 0 {
+		return nil, "", fmt.Errorf("%w: file access is disabled", ErrFileAccessDenied)
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the path is checked before symlinks are followed, so files outside of the roots
+	// are rejected without revealing whether they exist
+	if _, err := s.allows(abs, write, filepath.Abs); err != nil {
+		return nil, "", err
+	}
+	realName, err := realPath(abs)
+	if err != nil {
+		return nil, "", err
+	}
+	dir, err := s.allows(realName, write, realPath)
+	if err != nil {
+		return nil, "", err
+	}
+	rel, err := filepath.Rel(dir, realName)
+	if err != nil {
+		return nil, "", err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	return root, rel, nil
+}
+
+// allows checks that a root, whose directory is turned into a path comparable to name by
+// dirPath, contains name and allows the access, it returns the directory of that root
+func (s *Sandbox) allows(name string, write bool, dirPath func(string) (string, error)) (string, error) {
+	readOnly := false
+	for _, root := range s.Roots {
+		dir, err := dirPath(root.Dir)
+		if err != nil || !within(dir, name) {
+			continue
+		}
+		if write && !root.Writable {
+			readOnly = true
+			continue
+		}
+		return dir, nil
+	}
+	if readOnly {
+		return "", fmt.Errorf("%w: read-only directory", ErrFileAccessDenied)
+	}
+	return "", fmt.Errorf("%w: outside of the sandbox", ErrFileAccessDenied)
+}
+
+// realPath makes name absolute and follows its symlinks. The file does not need to exist,
+// so that it can be created, but its directory does.
+func realPath(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	realName, err := filepath.EvalSymlinks(abs)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return realName, err
+	}
+	if _, err := os.Lstat(abs); err == nil {
+		// a symlink to a missing file, writing would create the file wherever it points to
+		return "", fmt.Errorf("%w: dangling symlink", ErrFileAccessDenied)
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(abs))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(abs)), nil
+}
+
+func within(dir, name string) bool {
+	rel, err := filepath.Rel(dir, name)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// fsPath turns name into a path of a virtual filesystem, paths leaving its root are rejected
+func fsPath(name string) (string, error) {
+	fsName := strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "/")
+	if fsName == "" {
+		fsName = "."
+	}
+	if !fs.ValidPath(fsName) {
+		return "", fmt.Errorf("%w: outside of the sandbox", ErrFileAccessDenied)
+	}
+	return fsName, nil
+}
@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"monkey-int/token"
+	"unicode/utf8"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Span is the source range a diagnostic refers to, End is exclusive
+type Span struct {
+	Start token.Position
+	End   token.Position
+}
+
+// Diagnostic is a problem found while lexing or parsing
+type Diagnostic struct {
+	Severity Severity
+	Span     Span
+	Message  string
+	Hint     string // suggestion on how to fix the problem, may be empty
+}
+
+// String returns the message prefixed with its start position
+func (d Diagnostic) String() string {
+	if d.Span.Start.IsValid() {
+		return d.Span.Start.String() + ": " + d.Message
+	}
+	return d.Message
+}
+
+// tokenSpan returns the span covered by a token
+func tokenSpan(tok token.Token) Span {
+	end := tok.Pos
+	end.Column += utf8.RuneCountInString(tok.Literal)
+	return Span{Start: tok.Pos, End: end}
+}
+
+// describeType names a token type the way it is written in source
+func describeType(t token.TokenType) string {
+	switch t {
+	case token.IDENTIFIER:
+		return "identifier"
+	case token.INT, token.FLOAT:
+		return "number"
+	case token.STRING:
+		return "string"
+	case token.TEMPLATE_PART, token.TEMPLATE_END:
+		return `"}"`
+	case token.EOF:
+		return "end of input"
+	}
+	if keyword, ok := token.Keyword(t); ok {
+		return `"` + keyword + `"`
+	}
+	return `"` + string(t) + `"`
+}
+
+// describeToken names a token found in the source, including the literal where useful
+func describeToken(tok token.Token) string {
+	switch tok.Type {
+	case token.IDENTIFIER, token.INT, token.FLOAT:
+		return describeType(tok.Type) + " " + tok.Literal
+	case token.STRING:
+		return "string " + `"` + tok.Literal + `"`
+	case token.ILLEGAL:
+		return "illegal character " + `"` + tok.Literal + `"`
+	}
+	return describeType(tok.Type)
+}
+
+var statementKeywords = map[token.TokenType]bool{
+	token.LET:      true,
+	token.RETURN:   true,
+	token.WHILE:    true,
+	token.FOR:      true,
+	token.BREAK:    true,
+	token.CONTINUE: true,
+	token.IMPORT:   true,
+}
@@ -0,0 +1,22 @@
+package parser
+
+import (
+	"fmt"
+	"monkey-int/ast"
+	"monkey-int/lexer"
+)
+
+// ParseFile parses the source of a file, such as an imported module. Syntax errors are
+// returned as a single error naming the first diagnostic.
+func ParseFile(filename string, src []byte) (*ast.Program, error) {
+	p := New(lexer.NewWithFile(filename, string(src)))
+	program := p.ParseProgram()
+	switch errors := p.Errors(); len(errors) {
+	case 0:
+		return program, nil
+	case 1:
+		return nil, fmt.Errorf("%s", errors[0])
+	default:
+		return nil, fmt.Errorf("%s (and %d more errors)", errors[0], len(errors)-1)
+	}
+}
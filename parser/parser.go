@@ -6,33 +6,56 @@ import (
 	"monkey-int/lexer"
 	"monkey-int/token"
 	"strconv"
+	"strings"
 )
 
 // precendences
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // =, +=, -=, *=, /=
+	OR          // ||
+	AND         // &&
 	EQUALS      // ==
 	LESSGREATER // >/<
-	SUM         // +
-	PRODUCT     // *
-	PREFIX      // -x/!x
+	SUM         // +, |, ^
+	PRODUCT     // *, %, &, <<, >>
+	PREFIX      // -x/!x/~x
 	CALL        // function(x)
 	INDEX       // array[index]
 )
 
 var precendences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
-}
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.OR:              OR,
+	token.AND:             AND,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.BIT_OR:          SUM,
+	token.BIT_XOR:         SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.BIT_AND:         PRODUCT,
+	token.SHIFT_LEFT:      PRODUCT,
+	token.SHIFT_RIGHT:     PRODUCT,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
+}
+
+// MaxNestingDepth bounds the depth of the syntax tree, which the compiler and the evaluator
+// walk recursively: a deeper tree could overflow the Go stack, which can't be recovered from
+const MaxNestingDepth = 10000
 
 type (
 	prefixParseFn func() ast.Expression
@@ -45,14 +68,28 @@ type Parser struct {
 	curToken  token.Token
 	peekToken token.Token
 
-	errors []string
+	diagnostics     []Diagnostic
+	lexerErrorCount int // number of lexer errors already copied to diagnostics
+
+	// set after an error until the parser has resynchronized, further errors are
+	// suppressed in the meantime as they are most likely caused by the first one
+	panicking bool
+	brackets  []token.TokenType // "(", "[" and "{" read and not closed yet, innermost last
+	braces    int               // number of "{" in brackets
+
+	// text of the /// comments read since the last statement started
+	docComments []string
+
+	loopDepth  int // number of loops enclosing the current statement within the current function
+	blockDepth int // number of blocks enclosing the current statement, 0 at the top level
+	nesting    int // depth of the node being parsed in the syntax tree, bounded by MaxNestingDepth
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l, diagnostics: []Diagnostic{}}
 
 	// Read 2 tokens so curToken and peekToken are both set
 	p.nextToken()
@@ -62,14 +99,18 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENTIFIER, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.TRUE, p.parseBooleanLiteral)
 	p.registerPrefix(token.FALSE, p.parseBooleanLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.BIT_NOT, p.parsePrefixExpression)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.TEMPLATE_PART, p.parseInterpolatedString)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 
@@ -82,24 +123,146 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.BIT_AND, p.parseInfixExpression)
+	p.registerInfix(token.BIT_OR, p.parseInfixExpression)
+	p.registerInfix(token.BIT_XOR, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_LEFT, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_RIGHT, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 
 	return p
 }
 
-func (p *Parser) Errors() []string {
-	return p.errors
+// Errors returns the diagnostics reported by the lexer and parser, in source order of discovery
+func (p *Parser) Errors() []Diagnostic {
+	return p.diagnostics
+}
+
+// addError reports an error at the given token
+func (p *Parser) addError(tok token.Token, format string, a ...interface{}) {
+	p.addErrorWithHint(tok, "", format, a...)
+}
+
+// addErrorWithHint reports an error at the given token along with a suggestion on how to fix it.
+// Errors are dropped while the parser is recovering from a previous one, and only the first
+// error at any position is kept.
+func (p *Parser) addErrorWithHint(tok token.Token, hint string, format string, a ...interface{}) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+	p.addDiagnostic(Diagnostic{
+		Severity: SeverityError,
+		Span:     tokenSpan(tok),
+		Message:  fmt.Sprintf(format, a...),
+		Hint:     hint,
+	})
+}
+
+func (p *Parser) addDiagnostic(d Diagnostic) {
+	for _, existing := range p.diagnostics {
+		if existing.Span.Start == d.Span.Start {
+			return
+		}
+	}
+	p.diagnostics = append(p.diagnostics, d)
+}
+
+// synchronize skips the tokens of a statement that failed to parse. It stops at the end of
+// the statement (a ";"), before a "}" or a keyword starting a new statement, or at the end of
+// the line, as long as all braces, respectively all brackets, the statement opened are closed.
+// brackets is the number of open brackets when the statement started. It returns true if the
+// current token is the "}" closing the enclosing block.
+func (p *Parser) synchronize(brackets int) bool {
+	p.panicking = false
+
+	// braces opened before the statement started
+	outerBraces := 0
+	for _, b := range p.brackets[:min(brackets, len(p.brackets))] {
+		if b == token.LBRACE {
+			outerBraces++
+		}
+	}
+	for !p.curTokenIs(token.EOF) {
+		if p.curTokenIs(token.RBRACE) && len(p.brackets) < brackets {
+			return true
+		}
+		braces := p.braces - outerBraces
+		if braces == 0 && p.curTokenIs(token.SEMICOLON) {
+			return false
+		}
+		if braces == 0 && (p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.EOF) || statementKeywords[p.peekToken.Type]) {
+			return false
+		}
+		if len(p.brackets) <= brackets && p.peekToken.Pos.Line > p.curToken.Pos.Line {
+			return false
+		}
+		p.nextToken()
+	}
+	return false
+}
+
+// trackBrackets keeps the brackets that are open after the current token. A "}" closes the
+// innermost "{" and everything opened within it, other unmatched closing brackets are ignored.
+func (p *Parser) trackBrackets() {
+	switch p.curToken.Type {
+	case token.LPAREN, token.LBRACKET, token.LBRACE:
+		p.brackets = append(p.brackets, p.curToken.Type)
+		if p.curToken.Type == token.LBRACE {
+			p.braces++
+		}
+	case token.RPAREN, token.RBRACKET:
+		open := token.TokenType(token.LPAREN)
+		if p.curToken.Type == token.RBRACKET {
+			open = token.LBRACKET
+		}
+		if n := len(p.brackets); n > 0 && p.brackets[n-1] == open {
+			p.brackets = p.brackets[:n-1]
+		}
+	case token.RBRACE:
+		for i := len(p.brackets) - 1; i >= 0; i-- {
+			if p.brackets[i] == token.LBRACE {
+				p.brackets = p.brackets[:i]
+				p.braces--
+				break
+			}
+		}
+	}
 }
 
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
+	p.trackBrackets()
 	p.peekToken = p.l.NextToken()
+	for p.peekToken.Type == token.DOC_COMMENT {
+		p.docComments = append(p.docComments, p.peekToken.Literal)
+		p.peekToken = p.l.NextToken()
+	}
+
+	lexerErrors := p.l.Errors()
+	for _, err := range lexerErrors[p.lexerErrorCount:] {
+		p.addDiagnostic(Diagnostic{Severity: SeverityError, Span: Span{Start: err.Pos, End: err.Pos}, Message: err.Msg})
+	}
+	p.lexerErrorCount = len(lexerErrors)
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("Expected next token=%s, got %s instead.", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	hint := ""
+	if p.peekTokenIs(token.EOF) {
+		hint = "the input ends too early"
+	}
+	p.addErrorWithHint(p.peekToken, hint, "expected %s, found %s", describeType(t), describeToken(p.peekToken))
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -107,8 +270,11 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for p.curToken.Type != token.EOF {
+		brackets := len(p.brackets)
 		statement := p.parseStatement()
-		if statement != nil {
+		if p.panicking {
+			p.synchronize(brackets)
+		} else if statement != nil {
 			program.Statements = append(program.Statements, statement)
 		}
 		p.nextToken()
@@ -118,11 +284,28 @@ func (p *Parser) ParseProgram() *ast.Program {
 }
 
 func (p *Parser) parseStatement() ast.Statement {
+	doc := strings.Join(p.docComments, "\n")
+	p.docComments = nil
+
 	switch p.curToken.Type {
 	case token.LET:
-		return p.parseLetStatement()
+		statement := p.parseLetStatement()
+		if statement != nil {
+			statement.Doc = doc
+		}
+		return statement
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
+	case token.IMPORT:
+		return p.parseImportStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -144,6 +327,10 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 	statement.Value = p.parseExpression(LOWEST)
 
+	if fl, ok := statement.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = statement.Name.Value
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -165,6 +352,115 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return statement
 }
 
+func (p *Parser) parseWhileStatement() ast.Statement {
+	statement := &ast.WhileStatement{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil // incorrectly formatted while loop
+	}
+	p.nextToken()
+	statement.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil // incorrectly formatted while loop
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil // incorrectly formatted while loop
+	}
+
+	statement.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return statement
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
+	statement := &ast.ForStatement{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil // incorrectly formatted for loop
+	}
+	if !p.expectPeek(token.IDENTIFIER) {
+		return nil // incorrectly formatted for loop
+	}
+	statement.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.IN) {
+		return nil // incorrectly formatted for loop
+	}
+	p.nextToken()
+	statement.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil // incorrectly formatted for loop
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil // incorrectly formatted for loop
+	}
+
+	statement.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return statement
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+	return p.parseBlockStatement()
+}
+
+func (p *Parser) parseBreakStatement() ast.Statement {
+	statement := &ast.BreakStatement{Token: p.curToken}
+	if p.loopDepth == 0 {
+		p.addErrorWithHint(p.curToken, "break can only be used inside a while or for loop", "break outside of loop")
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return statement
+}
+
+func (p *Parser) parseImportStatement() ast.Statement {
+	statement := &ast.ImportStatement{Token: p.curToken}
+	if p.blockDepth > 0 {
+		p.addErrorWithHint(p.curToken, "move the import to the top level of the file", "import is only allowed at the top level")
+		return nil
+	}
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	statement.Path = p.curToken.Literal
+
+	if !p.expectPeek(token.AS) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENTIFIER) {
+		return nil
+	}
+	statement.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return statement
+}
+
+func (p *Parser) parseContinueStatement() ast.Statement {
+	statement := &ast.ContinueStatement{Token: p.curToken}
+	if p.loopDepth == 0 {
+		p.addErrorWithHint(p.curToken, "continue can only be used inside a while or for loop", "continue outside of loop")
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return statement
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	statement := &ast.ExpressionStatement{Token: p.curToken}
 	statement.Expression = p.parseExpression(LOWEST)
@@ -203,11 +499,16 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 }
 
 func (p *Parser) parseExpression(precendence int) ast.Expression {
+	nesting := p.nesting
+	defer func() { p.nesting = nesting }()
+	if !p.nest() {
+		return nil
+	}
+
 	// Prefix also parses (integer) literals
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
-		msg := fmt.Sprintf("No prefix parse function for %s found", p.curToken.Type)
-		p.errors = append(p.errors, msg)
+		p.noPrefixParseFnError()
 		return nil
 	}
 	leftExpression := prefix()
@@ -217,6 +518,10 @@ func (p *Parser) parseExpression(precendence int) ast.Expression {
 		if infix == nil {
 			return leftExpression
 		}
+		// the left expression becomes an operand of the infix expression, one level deeper
+		if !p.nest() {
+			return nil
+		}
 		p.nextToken()
 		leftExpression = infix(leftExpression)
 	}
@@ -224,6 +529,29 @@ func (p *Parser) parseExpression(precendence int) ast.Expression {
 	return leftExpression
 }
 
+// nest descends one level deeper into the syntax tree, it reports an error and returns false
+// if the tree gets deeper than MaxNestingDepth
+func (p *Parser) nest() bool {
+	p.nesting++
+	if p.nesting > MaxNestingDepth {
+		p.addErrorWithHint(p.curToken, "split it up using variables or functions",
+			"code nested too deeply, more than %d levels", MaxNestingDepth)
+		return false
+	}
+	return true
+}
+
+func (p *Parser) noPrefixParseFnError() {
+	hint := ""
+	switch p.curToken.Type {
+	case token.RPAREN, token.RBRACKET, token.RBRACE:
+		hint = "check for unbalanced brackets"
+	case token.EOF:
+		hint = "the input ends too early"
+	}
+	p.addErrorWithHint(p.curToken, hint, "expected expression, found %s", describeToken(p.curToken))
+}
+
 func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
@@ -233,8 +561,20 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("Could not parse %q as int64", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(p.curToken, "Could not parse %q as int64", p.curToken.Literal)
+		return nil
+	}
+
+	literal.Value = value
+	return literal
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	literal := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.addError(p.curToken, "Could not parse %q as float64", p.curToken.Literal)
 		return nil
 	}
 
@@ -278,13 +618,32 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Target:   target,
+	}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		p.addErrorWithHint(p.curToken, "only variables and index expressions can be assigned to", "cannot assign to %s", target.String())
+		return nil
+	}
+
+	p.nextToken()
+	// parsed with the lowest precedence to make assignments right associative: a = b = 1
+	expression.Value = p.parseExpression(LOWEST)
+	return expression
+}
+
 func (p *Parser) parseBooleanLiteral() ast.Expression {
 	literal := &ast.Boolean{Token: p.curToken}
 
 	value, err := strconv.ParseBool(p.curToken.Literal)
 	if err != nil {
-		msg := fmt.Sprintf("Could not parse %q as bool", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(p.curToken, "Could not parse %q as bool", p.curToken.Literal)
 		return nil
 	}
 
@@ -329,18 +688,62 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+	if !p.expectPeek(token.LBRACE) {
+		return nil // incorrectly formatted try expression
+	}
+	expression.Block = p.parseBlockStatement()
+
+	if !p.expectPeek(token.CATCH) {
+		return nil // try without catch
+	}
+	if !p.expectPeek(token.LPAREN) {
+		return nil // incorrectly formatted catch clause
+	}
+	if !p.expectPeek(token.IDENTIFIER) {
+		return nil // incorrectly formatted catch clause
+	}
+	expression.Parameter = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.RPAREN) {
+		return nil // incorrectly formatted catch clause
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil // incorrectly formatted catch clause
+	}
+	expression.Handler = p.parseBlockStatement()
+	return expression
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
+	nesting := p.nesting
+	defer func() { p.nesting = nesting }()
+	if !p.nest() {
+		return block
+	}
 	p.nextToken()
 
+	p.blockDepth++
+	defer func() { p.blockDepth-- }()
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		brackets := len(p.brackets)
 		statement := p.parseStatement()
-		if statement != nil {
+		if p.panicking {
+			if p.synchronize(brackets) {
+				break
+			}
+		} else if statement != nil {
 			block.Statements = append(block.Statements, statement)
 		}
 		p.nextToken()
 	}
+
+	if p.curTokenIs(token.EOF) {
+		p.addErrorWithHint(p.curToken, fmt.Sprintf("the block opened at %s is never closed", block.Token.Pos),
+			"expected %s, found %s", describeType(token.RBRACE), describeToken(p.curToken))
+	}
 	return block
 }
 
@@ -356,7 +759,11 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil // incorrectly formatted function
 	}
 
+	// loops around the function literal can't be exited from within its body
+	outerLoopDepth := p.loopDepth
+	p.loopDepth = 0
 	literal.Body = p.parseBlockStatement()
+	p.loopDepth = outerLoopDepth
 	return literal
 }
 
@@ -423,6 +830,33 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{Token: p.curToken}
+
+	for {
+		// curToken is a TEMPLATE_PART or the TEMPLATE_END
+		if p.curToken.Literal != "" {
+			str.Parts = append(str.Parts, &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
+		}
+		if p.curToken.Type == token.TEMPLATE_END {
+			return str
+		}
+
+		p.nextToken()
+		part := p.parseExpression(LOWEST)
+		if part == nil {
+			return nil
+		}
+		str.Parts = append(str.Parts, part)
+
+		if p.peekTokenIs(token.TEMPLATE_PART) {
+			p.nextToken()
+		} else if !p.expectPeek(token.TEMPLATE_END) {
+			return nil
+		}
+	}
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	return &ast.ArrayLiteral{
 		Token:    p.curToken,
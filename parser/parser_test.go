@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"monkey-int/ast"
 	"monkey-int/lexer"
+	"monkey-int/token"
+	"strings"
 	"testing"
 )
 
@@ -108,7 +110,7 @@ func checkParserErrors(t *testing.T, p *Parser) {
 
 	t.Errorf("Parser has %d errors!", len(errors))
 	for _, msg := range errors {
-		t.Errorf("Parser error: %q.", msg)
+		t.Errorf("Parser error: %q.", msg.String())
 	}
 	t.FailNow()
 }
@@ -416,6 +418,26 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"a <= b && c >= d || !e",
+			"(((a <= b) && (c >= d)) || (!e))",
+		},
+		{
+			"a + b % c - d",
+			"((a + (b % c)) - d)",
+		},
+		{
+			"a | b & c ^ ~d",
+			"((a | (b & c)) ^ (~d))",
+		},
+		{
+			"1 << 2 + 3 >> 1",
+			"((1 << 2) + (3 >> 1))",
+		},
 	}
 	for _, tt := range tests {
 		l := lexer.New(tt.input)
@@ -939,3 +961,456 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		testFunc(value)
 	}
 }
+
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = fn() { };`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Body does not contain 1 statement. Got=%d instead.", len(program.Statements))
+	}
+
+	statement, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not an ast.LetStatement. Got=%T instead.", program.Statements[0])
+	}
+	function, ok := statement.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("statement.Value is not an ast.FunctionLiteral. Got=%T instead.", statement.Value)
+	}
+	if function.Name != "myFunction" {
+		t.Fatalf("function literal name wrong. Wanted=%q, got=%q instead.", "myFunction", function.Name)
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"let x 5;", `script.mk:1:7: expected "=", found number 5`},
+		{"let x = 1;\nlet y = );", `script.mk:2:9: expected expression, found ")"`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewWithFile("script.mk", tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("Expected parser errors for %q, got none", tt.input)
+		}
+		if errors[0].String() != tt.expectedError {
+			t.Errorf("Wrong parser error. Wanted=%q, got=%q instead.", tt.expectedError, errors[0].String())
+		}
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"7.5;", 7.5},
+		{"0.25", 0.25},
+		{"1e3", 1000},
+		{"2.5E-1", 0.25},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("Program doesn't have enough statements. Got=%d statements.", len(program.Statements))
+		}
+
+		statement, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not an ast.ExpressionStatement. Got=%T instead.", program.Statements[0])
+		}
+
+		literal, ok := statement.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. Got=%T instead.", statement.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. Got=%g instead.", tt.expected, literal.Value)
+		}
+	}
+}
+
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x; break; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. Got=%d instead.", len(program.Statements))
+	}
+	statement, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not an ast.WhileStatement. Got=%T instead.", program.Statements[0])
+	}
+	if !testInfixExpression(t, statement.Condition, "x", "<", "y") {
+		return
+	}
+	if len(statement.Body.Statements) != 2 {
+		t.Fatalf("Body does not contain 2 statements. Got=%d instead.", len(statement.Body.Statements))
+	}
+	if _, ok := statement.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Fatalf("Body.Statements[1] is not an ast.BreakStatement. Got=%T instead.", statement.Body.Statements[1])
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	input := `for (x in [1, 2]) { continue; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. Got=%d instead.", len(program.Statements))
+	}
+	statement, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not an ast.ForStatement. Got=%T instead.", program.Statements[0])
+	}
+	if !testIdentifier(t, statement.Variable, "x") {
+		return
+	}
+	if _, ok := statement.Iterable.(*ast.ArrayLiteral); !ok {
+		t.Fatalf("Iterable is not an ast.ArrayLiteral. Got=%T instead.", statement.Iterable)
+	}
+	if len(statement.Body.Statements) != 1 {
+		t.Fatalf("Body does not contain 1 statement. Got=%d instead.", len(statement.Body.Statements))
+	}
+	if _, ok := statement.Body.Statements[0].(*ast.ContinueStatement); !ok {
+		t.Fatalf("Body.Statements[0] is not an ast.ContinueStatement. Got=%T instead.", statement.Body.Statements[0])
+	}
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"break;", "1:1: break outside of loop"},
+		{"if (true) { continue; }", "1:13: continue outside of loop"},
+		{"while (true) { fn() { break; } }", "1:23: break outside of loop"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("Expected parser errors for %q, got none", tt.input)
+		}
+		if errors[0].String() != tt.expectedError {
+			t.Errorf("Wrong parser error. Wanted=%q, got=%q instead.", tt.expectedError, errors[0].String())
+		}
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a = 5", "(a = 5)"},
+		{"a += 1 + 2", "(a += (1 + 2))"},
+		{"a = b = c", "(a = (b = c))"},
+		{"a[1] *= 2", "((a[1]) *= 2)"},
+		{`h["k"] /= x - 1`, "((h[k]) /= (x - 1))"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("Expected=%q, got=%q instead.", tt.expected, actual)
+		}
+	}
+}
+
+func TestInvalidAssignmentTarget(t *testing.T) {
+	l := lexer.New("1 = 2")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("Expected parser errors, got none")
+	}
+	if errors[0].String() != "1:3: cannot assign to 1" {
+		t.Errorf("Wrong parser error. Got=%q", errors[0].String())
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `
+/// Adds two numbers.
+/// Returns their sum.
+let add = fn(a, b) { a + b }; // not documentation
+/* block */ let two = add(1, 1);
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("Expected 2 statements, got=%d", len(program.Statements))
+	}
+
+	expectedDocs := []string{"Adds two numbers.\nReturns their sum.", ""}
+	for i, expected := range expectedDocs {
+		statement, ok := program.Statements[i].(*ast.LetStatement)
+		if !ok {
+			t.Fatalf("Statement %d is not *ast.LetStatement. Got=%T", i, program.Statements[i])
+		}
+		if statement.Doc != expected {
+			t.Errorf("Statement %d has wrong doc. Wanted=%q, got=%q instead.", i, expected, statement.Doc)
+		}
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	l := lexer.New("let a = 1; /* no end")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 || errors[0].String() != "1:12: unterminated block comment" {
+		t.Errorf("Wrong parser errors. Got=%q", errors)
+	}
+}
+
+func TestInterpolatedString(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      string
+		expectedParts int
+	}{
+		{`"hello ${name}, you are ${age + 1}"`, `"hello ${name}, you are ${(age + 1)}"`, 4},
+		{`"${x}"`, `"${x}"`, 1},
+		{`"a ${"b ${c}"} d"`, `"a ${"b ${c}"} d"`, 3},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		statement := program.Statements[0].(*ast.ExpressionStatement)
+		str, ok := statement.Expression.(*ast.InterpolatedString)
+		if !ok {
+			t.Fatalf("Expression is not *ast.InterpolatedString. Got=%T", statement.Expression)
+		}
+		if str.String() != tt.expected {
+			t.Errorf("Expected=%q, got=%q instead.", tt.expected, str.String())
+		}
+		if len(str.Parts) != tt.expectedParts {
+			t.Errorf("Wrong number of parts. Expected=%d, got=%d", tt.expectedParts, len(str.Parts))
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedErrors     []string
+		expectedStatements int
+	}{
+		// resynchronizes at ";" and reports each broken statement once
+		{"let x 5; let y = 2; let = 3;", []string{`1:7: expected "=", found number 5`, `1:25: expected identifier, found "="`}, 1},
+		// no cascade from the unbalanced parenthesis
+		{"let a = (1 + 2; let b = 3;", []string{`1:15: expected ")", found ";"`}, 1},
+		// resynchronizes at statement keywords without a ";"
+		{"let a = * 2\nlet b = 1\nreturn b", []string{`1:9: expected expression, found "*"`}, 2},
+		// resynchronizes within blocks and keeps parsing the enclosing function
+		{"let f = fn() { let = 1; 2 }; let g = fn() { ) }; f()", []string{`1:20: expected identifier, found "="`, `1:45: expected expression, found ")"`}, 3},
+		// braces opened by the broken statement are skipped
+		{"if (x { 1 } let y = 2;", []string{`1:7: expected ")", found "{"`}, 1},
+		{"let f = fn() { 1", []string{`1:17: expected "}", found end of input`}, 0},
+		// resynchronizes at the start of the next line without a ";" or keyword
+		{"a + * 1\nb + * 2", []string{`1:5: expected expression, found "*"`, `2:5: expected expression, found "*"`}, 0},
+		{"puts(1 2)\nputs(3)\nputs(4 5)", []string{`1:8: expected ")", found number 2`, `3:8: expected ")", found number 5`}, 1},
+		{"let f = fn() {\n  a + * 1\n  b + * 2\n}", []string{`2:7: expected expression, found "*"`, `3:7: expected expression, found "*"`}, 1},
+		// but not within the brackets opened by the broken statement
+		{"let h = {\n  \"a\": * 1,\n  \"b\": 2\n};\nlet c = * 1", []string{`2:8: expected expression, found "*"`, `5:9: expected expression, found "*"`}, 0},
+		{"let a = (1 +\n  * 2)\nb + * 1", []string{`2:3: expected expression, found "*"`, `3:5: expected expression, found "*"`}, 0},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Errorf("Wrong number of errors for %q. Wanted=%d, got=%d: %q", tt.input, len(tt.expectedErrors), len(errors), errors)
+			continue
+		}
+		for i, expected := range tt.expectedErrors {
+			if errors[i].String() != expected {
+				t.Errorf("Wrong parser error %d for %q. Wanted=%q, got=%q instead.", i, tt.input, expected, errors[i].String())
+			}
+		}
+		if len(program.Statements) != tt.expectedStatements {
+			t.Errorf("Wrong number of statements for %q. Wanted=%d, got=%d: %q", tt.input, tt.expectedStatements, len(program.Statements), program.String())
+		}
+	}
+}
+
+func TestNestingDepth(t *testing.T) {
+	n := MaxNestingDepth
+	tests := []struct {
+		input string
+		ok    bool
+	}{
+		{strings.Repeat("1 + ", n/2) + "1", true},
+		{strings.Repeat("1 + ", 100*n) + "1", false},
+		{strings.Repeat("(", n/2) + "1" + strings.Repeat(")", n/2), true},
+		{strings.Repeat("(", 10*n) + "1" + strings.Repeat(")", 10*n), false},
+		{strings.Repeat("-", 10*n) + "1", false},
+		{strings.Repeat("while (true) { ", n+1) + strings.Repeat("}", n+1), false},
+		{"let a = " + strings.Repeat("[", 10*n) + strings.Repeat("]", 10*n) + "; let b = 1;", false},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		errors := p.Errors()
+		if tt.ok {
+			if len(errors) != 0 {
+				t.Errorf("Unexpected errors for %.20q: %q", tt.input, errors)
+			}
+			continue
+		}
+		if len(errors) != 1 || !strings.HasPrefix(errors[0].Message, "code nested too deeply") {
+			t.Errorf("Expected a nesting error for %.20q, got=%q", tt.input, errors)
+		}
+		if len(program.Statements) > 1 {
+			t.Errorf("Expected the nested statement to be dropped, got=%d statements", len(program.Statements))
+		}
+	}
+}
+
+func TestDiagnostic(t *testing.T) {
+	l := lexer.New("let x = 1;\nlet y = foo);")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got=%d: %q", len(errors), errors)
+	}
+
+	expected := Diagnostic{
+		Severity: SeverityError,
+		Span:     Span{Start: token.Position{Line: 2, Column: 12}, End: token.Position{Line: 2, Column: 13}},
+		Message:  `expected expression, found ")"`,
+		Hint:     "check for unbalanced brackets",
+	}
+	if errors[0] != expected {
+		t.Errorf("Wrong diagnostic. Wanted=%+v, got=%+v instead.", expected, errors[0])
+	}
+	if errors[0].Severity.String() != "error" {
+		t.Errorf("Wrong severity. Got=%q", errors[0].Severity.String())
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	l := lexer.New(`try { risky(); } catch (err) { err }`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("Expected 1 statement, got=%d", len(program.Statements))
+	}
+	statement := program.Statements[0].(*ast.ExpressionStatement)
+	expression, ok := statement.Expression.(*ast.TryExpression)
+	if !ok {
+		t.Fatalf("Expression is not *ast.TryExpression. Got=%T", statement.Expression)
+	}
+	if len(expression.Block.Statements) != 1 {
+		t.Errorf("Try block has wrong number of statements. Got=%d", len(expression.Block.Statements))
+	}
+	if !testIdentifier(t, expression.Parameter, "err") {
+		return
+	}
+	if len(expression.Handler.Statements) != 1 {
+		t.Errorf("Catch block has wrong number of statements. Got=%d", len(expression.Handler.Statements))
+	}
+
+	l = lexer.New(`try { 1 } 2`)
+	p = New(l)
+	p.ParseProgram()
+	errors := p.Errors()
+	if len(errors) != 1 || errors[0].String() != `1:11: expected "catch", found number 2` {
+		t.Errorf("Wrong parser errors. Got=%q", errors)
+	}
+}
+
+func TestImportStatement(t *testing.T) {
+	l := lexer.New(`import "lib/strings.mk" as s; s`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("Expected 2 statements, got=%d", len(program.Statements))
+	}
+	statement, ok := program.Statements[0].(*ast.ImportStatement)
+	if !ok {
+		t.Fatalf("Statement is not *ast.ImportStatement. Got=%T", program.Statements[0])
+	}
+	if statement.Path != "lib/strings.mk" {
+		t.Errorf("Wrong import path. Got=%q", statement.Path)
+	}
+	if !testIdentifier(t, statement.Name, "s") {
+		return
+	}
+	if statement.String() != `import "lib/strings.mk" as s;` {
+		t.Errorf("Wrong string representation. Got=%q", statement.String())
+	}
+
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{`import lib as s`, `1:8: expected string, found identifier lib`},
+		{`import "lib.mk" s`, `1:17: expected "as", found identifier s`},
+		{`let f = fn() { import "lib.mk" as s }`, `1:16: import is only allowed at the top level`},
+		{`if (true) { import "lib.mk" as s }`, `1:13: import is only allowed at the top level`},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) != 1 || errors[0].String() != tt.expectedError {
+			t.Errorf("Wrong parser errors for %q. Wanted=%q, got=%q", tt.input, tt.expectedError, errors)
+		}
+	}
+}
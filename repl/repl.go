@@ -2,6 +2,7 @@ package repl
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"monkey-int/compiler"
@@ -10,16 +11,28 @@ import (
 	"monkey-int/object"
 	"monkey-int/parser"
 	"monkey-int/vm"
-	"os"
 )
 
 const PROMPT = ">> "
 
-func Start(in io.Reader, out io.Writer) {
-	useInterpreter := false
-	if len(os.Args) >= 2 && os.Args[1] == "-int" {
+// Engines selectable for the REPL and for running scripts
+const (
+	EngineEval = "eval" // tree-walking interpreter
+	EngineVM   = "vm"   // bytecode compiler and virtual machine
+)
+
+// Options configures how the REPL and scripts are executed
+type Options struct {
+	Engine string // EngineEval or EngineVM
+
+	// CheckedArithmetic makes integer overflow an error instead of wrapping around
+	CheckedArithmetic bool
+}
+
+func Start(in io.Reader, out io.Writer, opts Options) {
+	useInterpreter := opts.Engine == EngineEval
+	if useInterpreter {
 		io.WriteString(out, "\nRunning in interpreter mode\n")
-		useInterpreter = true
 	} else {
 		io.WriteString(out, "\nRunning in compiler mode\n")
 	}
@@ -28,14 +41,18 @@ func Start(in io.Reader, out io.Writer) {
 
 	// Evaluator context
 	ctx := object.NewContext()
+	ctx.CheckedArithmetic = opts.CheckedArithmetic
 
 	// Compiler context
 	constants := []object.Object{}
 	globals := make([]object.Object, vm.GlobalsSize)
 	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
 
 	for {
-		fmt.Printf(PROMPT)
+		io.WriteString(out, PROMPT)
 		scanned := scanner.Scan()
 		if !scanned {
 			return
@@ -47,8 +64,11 @@ func Start(in io.Reader, out io.Writer) {
 		program := p.ParseProgram()
 
 		if len(p.Errors()) > 0 {
-			for _, message := range p.Errors() {
-				io.WriteString(out, "\t"+message+"\n")
+			for _, diagnostic := range p.Errors() {
+				io.WriteString(out, "\t"+diagnostic.String()+"\n")
+				if diagnostic.Hint != "" {
+					io.WriteString(out, "\t\thint: "+diagnostic.Hint+"\n")
+				}
 			}
 			continue
 		}
@@ -59,9 +79,15 @@ func Start(in io.Reader, out io.Writer) {
 				io.WriteString(out, evaluated.Inspect())
 				io.WriteString(out, "\n")
 			}
+			if errorValue, ok := evaluated.(*object.Error); ok {
+				io.WriteString(out, object.FormatStack(errorValue.Stack))
+			}
 
 		} else {
-			comp := compiler.NewWithState(symbolTable, constants)
+			// the symbols of a line are only kept if it compiles, otherwise names defined
+			// by it would refer to globals that are never set
+			lineSymbols := symbolTable.Copy()
+			comp := compiler.NewWithState(lineSymbols, constants)
 			err := comp.Compile(program)
 			if err != nil {
 				fmt.Fprintf(out, "Compilation error:\n %s\n", err)
@@ -69,18 +95,26 @@ func Start(in io.Reader, out io.Writer) {
 			}
 
 			code := comp.Bytecode()
+			symbolTable = lineSymbols
 			constants = code.Constants
 
 			machine := vm.NewWithGlobalsStore(code, globals)
+			machine.CheckedArithmetic = opts.CheckedArithmetic
 			err = machine.Run()
 			if err != nil {
 				fmt.Fprintf(out, "Executing bytecode failed:\n %s\n", err)
+				var runtimeErr *vm.RuntimeError
+				if errors.As(err, &runtimeErr) {
+					io.WriteString(out, object.FormatStack(runtimeErr.Stack))
+				}
 				continue
 			}
 
 			lastPopped := machine.LastPoppedStackElem()
-			io.WriteString(out, lastPopped.Inspect())
-			io.WriteString(out, "\n")
+			if lastPopped != nil {
+				io.WriteString(out, lastPopped.Inspect())
+				io.WriteString(out, "\n")
+			}
 		}
 	}
 }
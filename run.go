@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"monkey-int/ast"
+	"monkey-int/compiler"
+	"monkey-int/evaluator"
+	"monkey-int/lexer"
+	"monkey-int/object"
+	"monkey-int/parser"
+	"monkey-int/repl"
+	"monkey-int/vm"
+	"os"
+)
+
+// runFile executes a Monkey script or a compiled .mkc file and returns the process exit code.
+// The script arguments are available to the program as the `args` array, output of puts goes
+// to the standard output.
+func runFile(filename string, scriptArgs []string, opts repl.Options, errOut io.Writer) int {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(errOut, "could not read %s: %s\n", filename, err)
+		return 1
+	}
+
+	argsArray := &object.Array{Elements: []object.Object{}}
+	for _, arg := range scriptArgs {
+		argsArray.Elements = append(argsArray.Elements, &object.String{Value: arg})
+	}
+
+	if compiler.IsSerializedBytecode(src) {
+		if opts.Engine == repl.EngineEval {
+			fmt.Fprintf(errOut, "%s: compiled scripts can only be run with the vm engine\n", filename)
+			return 1
+		}
+		bytecode := &compiler.MyBytecode{}
+		if err := bytecode.UnmarshalBinary(src); err != nil {
+			fmt.Fprintf(errOut, "could not load %s: %s\n", filename, err)
+			return 1
+		}
+		return runBytecode(bytecode, argsArray, opts, errOut)
+	}
+
+	program, ok := parseSource(filename, string(src), errOut)
+	if !ok {
+		return 1
+	}
+
+	if opts.Engine != repl.EngineEval {
+		bytecode, ok := compileScript(program, errOut)
+		if !ok {
+			return 1
+		}
+		return runBytecode(bytecode, argsArray, opts, errOut)
+	}
+
+	ctx := object.NewContext()
+	ctx.CheckedArithmetic = opts.CheckedArithmetic
+	ctx.Set("args", argsArray)
+	result := evaluator.Eval(program, ctx)
+	if errorValue, ok := result.(*object.Error); ok {
+		fmt.Fprintf(errOut, "runtime error: %s\n", errorValue.Describe())
+		fmt.Fprint(errOut, object.FormatStack(errorValue.Stack))
+		return 1
+	}
+	return 0
+}
+
+// runBytecode runs a program compiled by compileScript on the virtual machine
+func runBytecode(bytecode *compiler.MyBytecode, argsArray *object.Array, opts repl.Options, errOut io.Writer) int {
+	_, argsSymbol := scriptSymbolTable()
+	globals := make([]object.Object, vm.GlobalsSize)
+	globals[argsSymbol.Index] = argsArray
+
+	machine := vm.NewWithGlobalsStore(bytecode, globals)
+	machine.CheckedArithmetic = opts.CheckedArithmetic
+	err := machine.Run()
+	if err != nil {
+		fmt.Fprintf(errOut, "runtime error: %s\n", err)
+		var runtimeErr *vm.RuntimeError
+		if errors.As(err, &runtimeErr) {
+			fmt.Fprint(errOut, object.FormatStack(runtimeErr.Stack))
+		}
+		return 1
+	}
+
+	if errorValue, ok := machine.LastPoppedStackElem().(*object.Error); ok {
+		fmt.Fprintf(errOut, "runtime error: %s\n", errorValue.Describe())
+		fmt.Fprint(errOut, object.FormatStack(errorValue.Stack))
+		return 1
+	}
+	return 0
+}
+
+// parseFile reads and parses a script, errors are reported to errOut
+func parseFile(filename string, errOut io.Writer) (*ast.Program, bool) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(errOut, "could not read %s: %s\n", filename, err)
+		return nil, false
+	}
+	return parseSource(filename, string(src), errOut)
+}
+
+// parseSource parses the source of a script, errors are reported to errOut
+func parseSource(filename, src string, errOut io.Writer) (*ast.Program, bool) {
+	l := lexer.NewWithFile(filename, src)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		for _, diagnostic := range p.Errors() {
+			fmt.Fprintln(errOut, diagnostic)
+			if diagnostic.Hint != "" {
+				fmt.Fprintf(errOut, "\thint: %s\n", diagnostic.Hint)
+			}
+		}
+		return nil, false
+	}
+	return program, true
+}
+
+// compileScript compiles a script against scriptSymbolTable, errors are reported to errOut
+func compileScript(program *ast.Program, errOut io.Writer) (*compiler.MyBytecode, bool) {
+	symbolTable, _ := scriptSymbolTable()
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	if err := comp.Compile(program); err != nil {
+		fmt.Fprintf(errOut, "compilation error: %s\n", err)
+		return nil, false
+	}
+	return comp.Bytecode(), true
+}
+
+// scriptSymbolTable returns the global symbol table scripts are compiled with: the builtins
+// and the args array, whose symbol is returned as well
+func scriptSymbolTable() (*compiler.SymbolTable, compiler.Symbol) {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	return symbolTable, symbolTable.Define("args")
+}
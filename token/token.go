@@ -1,19 +1,52 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position
+}
+
+// Position is the location of a token in the source, lines and columns start at 1
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return p.File
+	}
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
 }
 
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
 
+	// DOC_COMMENT holds the text of a /// comment
+	DOC_COMMENT = "DOC_COMMENT"
+
+	// a string with ${...} interpolations is split into TEMPLATE_PART tokens, holding the
+	// text before each interpolated expression, and a final TEMPLATE_END token
+	TEMPLATE_PART = "TEMPLATE_PART"
+	TEMPLATE_END  = "TEMPLATE_END"
+
 	// Identifiers and literals
 	IDENTIFIER = "IDENTIFER"
 	INT        = "INT"
+	FLOAT      = "FLOAT"
 	STRING     = "STRING"
 
 	// Operators
@@ -23,9 +56,27 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
+
+	AND = "&&"
+	OR  = "||"
+
+	BIT_AND     = "&"
+	BIT_OR      = "|"
+	BIT_XOR     = "^"
+	BIT_NOT     = "~"
+	SHIFT_LEFT  = "<<"
+	SHIFT_RIGHT = ">>"
 
-	LT = "<"
-	GT = ">"
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+
+	LT    = "<"
+	GT    = ">"
+	LT_EQ = "<="
+	GT_EQ = ">="
 
 	// Delimiters
 	COMMA     = ","
@@ -47,19 +98,47 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	IMPORT   = "IMPORT"
+	AS       = "AS"
 
 	EQ     = "=="
 	NOT_EQ = "!="
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"try":      TRY,
+	"catch":    CATCH,
+	"import":   IMPORT,
+	"as":       AS,
+}
+
+// Keyword returns how the keyword of the given token type is spelled in source
+func Keyword(t TokenType) (string, bool) {
+	for keyword, tokenType := range keywords {
+		if tokenType == t {
+			return keyword, true
+		}
+	}
+	return "", false
 }
 
 func (t *Token) LookupIdent(ident string) TokenType {
@@ -1,19 +1,27 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"monkey-int/bytecode"
 	"monkey-int/compiler"
 	"monkey-int/object"
+	"monkey-int/token"
+	"strings"
+	"unicode/utf8"
 )
 
+// StackSize is the initial size of the stack, it grows up to MaxStackSize as the call depth
+// and the values pushed by the functions require, so that runs are limited by their budget
 const StackSize = 2048
+const MaxStackSize = 1 << 22
 const GlobalsSize = 65536
-const MaxFrames = 1024
 
-var VmTrue = &object.Boolean{Value: true}
-var VmFalse = &object.Boolean{Value: false}
-var VmNull = &object.Null{}
+var VmTrue = object.TRUE
+var VmFalse = object.FALSE
+var VmNull = object.NULL
 
 type VM struct {
 	constants []object.Object
@@ -21,23 +29,65 @@ type VM struct {
 	stack []object.Object
 	sp    int // stackpointer, always pointing to the next FREE slot in the stack
 
-	globals []object.Object
+	globals     []object.Object
+	globalNames []string
 
 	frames      []*Frame
 	framesIndex int
+
+	// CheckedArithmetic makes integer overflow a runtime error instead of wrapping around
+	CheckedArithmetic bool
+
+	// Sandbox restricts the files builtins can access, nil allows any file
+	Sandbox *object.Sandbox
+
+	meter object.Meter
+}
+
+// RuntimeError is an error raised while executing bytecode, annotated with the
+// source position of the instruction that failed
+type RuntimeError struct {
+	Pos   token.Position
+	Err   error
+	Stack []object.StackFrame // Monkey call stack, innermost first
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Err.Error()
+	}
+	return e.Err.Error()
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// thrownError is an error object returned by a builtin, such as throw, raised as a runtime error
+type thrownError struct {
+	err *object.Error
+}
+
+func (e *thrownError) Error() string {
+	return e.err.Message
 }
 
 func New(myBytecode *compiler.MyBytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: myBytecode.Instructions}
-	mainFrame := NewFrame(mainFn)
-	frames := make([]*Frame, MaxFrames)
-	frames[0] = mainFrame
+	mainFn := &object.CompiledFunction{
+		Instructions: myBytecode.Instructions,
+		SourceMap:    myBytecode.SourceMap,
+		Handlers:     myBytecode.Handlers,
+	}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+	frames := []*Frame{mainFrame}
 
 	return &VM{
 		constants:   myBytecode.Constants,
 		stack:       make([]object.Object, StackSize),
 		sp:          0,
 		globals:     make([]object.Object, GlobalsSize),
+		globalNames: myBytecode.GlobalNames,
 		frames:      frames,
 		framesIndex: 1,
 	}
@@ -56,13 +106,97 @@ func (vm *VM) StackTop() object.Object {
 	return vm.stack[vm.sp-1]
 }
 
+// Run executes the bytecode. A Go panic raised during execution, which means the VM
+// itself has a bug or was given malformed bytecode, is returned as a RuntimeError
+// carrying the Monkey call stack.
 func (vm *VM) Run() error {
+	return vm.RunWithBudget(context.Background(), object.Budget{})
+}
+
+// RunWithBudget is like Run, but aborts the execution with a RuntimeError wrapping
+// object.ErrBudgetExceeded once it exceeds budget or ctx is done
+func (vm *VM) RunWithBudget(ctx context.Context, budget object.Budget) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := vm.stackTrace()
+			err = &RuntimeError{Pos: stack[0].Pos, Err: fmt.Errorf("internal error: %v", r), Stack: stack}
+		}
+	}()
+	if err := vm.meter.Start(ctx, budget); err != nil {
+		return &RuntimeError{Err: err, Stack: vm.stackTrace()}
+	}
+
+	for {
+		err = vm.run()
+		if err == nil {
+			return nil
+		}
+		pos, stack := vm.currentPosition(), vm.stackTrace()
+		if !vm.handleError(err, stack) {
+			return &RuntimeError{Pos: pos, Err: err, Stack: stack}
+		}
+	}
+}
+
+// handleError unwinds the frames up to the innermost try expression covering the failed
+// instruction and continues with its handler, it reports false if there is none
+func (vm *VM) handleError(err error, stack []object.StackFrame) bool {
+	if errors.Is(err, object.ErrBudgetExceeded) {
+		return false
+	}
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		handler, ok := frame.cl.Fn.Handlers.Lookup(frame.ip)
+		if !ok {
+			continue
+		}
+
+		// the stack of the error ends with the frame catching it
+		stack = stack[:vm.framesIndex-i]
+		var caught *object.ErrorValue
+		if thrown, ok := err.(*thrownError); ok {
+			caught = thrown.err.Caught(stack)
+		} else {
+			caught = &object.ErrorValue{Message: err.Error(), Kind: object.RuntimeErrorKind, Stack: stack}
+		}
+
+		for vm.framesIndex > i+1 {
+			vm.popFrame()
+		}
+		vm.sp = frame.basePointer + frame.cl.Fn.NumLocals + handler.StackDepth
+		frame.ip = handler.Handler - 1
+		return vm.push(caught) == nil
+	}
+	return false
+}
+
+// stackTrace returns the active frames, innermost first
+func (vm *VM) stackTrace() []object.StackFrame {
+	stack := []object.StackFrame{}
+	for i := min(vm.framesIndex, len(vm.frames)) - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		name := "<main>"
+		if i > 0 {
+			name = object.FunctionName(frame.cl.Fn.Name)
+		}
+		stack = append(stack, object.StackFrame{Function: name, Pos: frame.cl.Fn.SourceMap.PositionAt(frame.ip)})
+	}
+	if len(stack) == 0 {
+		stack = append(stack, object.StackFrame{Function: "<main>"})
+	}
+	return stack
+}
+
+func (vm *VM) run() error {
 	var ip int
 	var ins bytecode.Instructions
 	var op bytecode.Opcode
 
 	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
 		vm.currentFrame().ip++
+		if err := vm.meter.Step(); err != nil {
+			return err
+		}
 
 		ip = vm.currentFrame().ip
 		ins = vm.currentFrame().Instructions()
@@ -71,6 +205,16 @@ func (vm *VM) Run() error {
 		switch op {
 		case bytecode.OpPop:
 			vm.pop()
+		case bytecode.OpDup:
+			count := int(bytecode.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			for _, value := range vm.stack[vm.sp-count : vm.sp] {
+				err := vm.push(value)
+				if err != nil {
+					return err
+				}
+			}
 		case bytecode.OpConstant:
 			constIndex := bytecode.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
@@ -78,7 +222,8 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case bytecode.OpAdd, bytecode.OpSub, bytecode.OpMul, bytecode.OpDiv:
+		case bytecode.OpAdd, bytecode.OpSub, bytecode.OpMul, bytecode.OpDiv, bytecode.OpMod,
+			bytecode.OpBitAnd, bytecode.OpBitOr, bytecode.OpBitXor, bytecode.OpShiftLeft, bytecode.OpShiftRight:
 			err := vm.executeBinaryOperation(op)
 			if err != nil {
 				return err
@@ -93,7 +238,8 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case bytecode.OpEqual, bytecode.OpNotEqual, bytecode.OpGreaterThan, bytecode.OpLessThan:
+		case bytecode.OpEqual, bytecode.OpNotEqual, bytecode.OpGreaterThan, bytecode.OpLessThan,
+			bytecode.OpGreaterEqual, bytecode.OpLessEqual:
 			err := vm.executeComparison(op)
 			if err != nil {
 				return err
@@ -106,10 +252,32 @@ func (vm *VM) Run() error {
 		case bytecode.OpMinus:
 			val := vm.pop()
 			if ival, ok := val.(*object.Integer); ok {
-				vm.push(&object.Integer{Value: -ival.Value})
+				result, ok := object.NegInt64(ival.Value)
+				if vm.CheckedArithmetic && !ok {
+					return fmt.Errorf("integer overflow: -%d", ival.Value)
+				}
+				err := vm.push(&object.Integer{Value: result})
+				if err != nil {
+					return err
+				}
+			} else if fval, ok := val.(*object.Float); ok {
+				err := vm.push(&object.Float{Value: -fval.Value})
+				if err != nil {
+					return err
+				}
 			} else {
 				return fmt.Errorf("Unsupported type for negation: %s", val.Type())
 			}
+		case bytecode.OpBitNot:
+			val := vm.pop()
+			ival, ok := val.(*object.Integer)
+			if !ok {
+				return fmt.Errorf("Unsupported type for bitwise not: %s", val.Type())
+			}
+			err := vm.push(&object.Integer{Value: ^ival.Value})
+			if err != nil {
+				return err
+			}
 		case bytecode.OpJump:
 			pos := int(bytecode.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip = pos - 1
@@ -134,13 +302,20 @@ func (vm *VM) Run() error {
 			globalIndex := bytecode.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
-			err := vm.push(vm.globals[globalIndex])
+			value := vm.globals[globalIndex]
+			if value == nil {
+				return undefinedVariable(vm.globalNames, int(globalIndex))
+			}
+			err := vm.push(value)
 			if err != nil {
 				return err
 			}
 		case bytecode.OpArray:
 			numElements := int(bytecode.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
+			if err := vm.meter.Alloc(object.ArraySize(numElements)); err != nil {
+				return err
+			}
 			array := vm.buildArray(vm.sp-numElements, vm.sp)
 			vm.sp = vm.sp - numElements
 			err := vm.push(array)
@@ -150,6 +325,9 @@ func (vm *VM) Run() error {
 		case bytecode.OpHash:
 			numElements := int(bytecode.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
+			if err := vm.meter.Alloc(object.HashSize(numElements / 2)); err != nil {
+				return err
+			}
 
 			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
 			if err != nil {
@@ -168,20 +346,244 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case bytecode.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			err := vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+		case bytecode.OpToString:
+			value := vm.pop()
+			var err error
+			if _, ok := value.(*object.String); ok {
+				err = vm.push(value)
+			} else {
+				err = vm.pushAllocated(&object.String{Value: value.Inspect()})
+			}
+			if err != nil {
+				return err
+			}
+		case bytecode.OpModule:
+			nameIndex := bytecode.ReadUint16(ins[ip+1:])
+			numExports := int(bytecode.ReadUint16(ins[ip+3:]))
+			vm.currentFrame().ip += 4
+
+			module := vm.buildModule(vm.constants[nameIndex], vm.sp-2*numExports, vm.sp)
+			vm.sp = vm.sp - 2*numExports
+
+			err := vm.push(module)
+			if err != nil {
+				return err
+			}
+		case bytecode.OpImport:
+			globalIndex := bytecode.ReadUint16(ins[ip+1:])
+			constIndex := bytecode.ReadUint16(ins[ip+3:])
+			vm.currentFrame().ip += 4
+
+			// the module function runs until a run succeeds, a module whose run failed is run
+			// again by the next import as the evaluator does
+			var err error
+			if module := vm.globals[globalIndex]; module != nil {
+				err = vm.push(module)
+			} else if err = vm.pushClosure(int(constIndex), 0); err == nil {
+				err = vm.callFunction(0)
+			}
+			if err != nil {
+				return err
+			}
+		case bytecode.OpConcat:
+			numParts := int(bytecode.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			str, err := vm.buildString(vm.sp-numParts, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numParts
+
+			err = vm.push(str)
+			if err != nil {
+				return err
+			}
+		case bytecode.OpIter:
+			iterable := vm.pop()
+			if err := vm.meter.Alloc(object.IterationSize(iterable)); err != nil {
+				return err
+			}
+			elements, ok := object.IterableElements(iterable)
+			if !ok {
+				return fmt.Errorf("cannot iterate over %s", iterable.Type())
+			}
+			err := vm.push(&object.Array{Elements: elements})
+			if err != nil {
+				return err
+			}
+		case bytecode.OpCall:
+			numArgs := bytecode.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.callFunction(int(numArgs))
+			if err != nil {
+				return err
+			}
+		case bytecode.OpReturnValue:
+			returnValue := vm.pop()
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1 // also drops the function itself
+
+			err := vm.push(returnValue)
+			if err != nil {
+				return err
+			}
+		case bytecode.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			err := vm.push(VmNull)
+			if err != nil {
+				return err
+			}
+		case bytecode.OpSetLocal:
+			localIndex := bytecode.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			slot := frame.basePointer + int(localIndex)
+			if cell, ok := vm.stack[slot].(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				vm.stack[slot] = vm.pop()
+			}
+		case bytecode.OpGetLocal:
+			localIndex := bytecode.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			value := vm.stack[frame.basePointer+int(localIndex)]
+			if cell, ok := value.(*object.Cell); ok {
+				value = cell.Value
+			}
+			if value == nil {
+				return undefinedVariable(frame.cl.Fn.LocalNames, int(localIndex))
+			}
+			err := vm.push(value)
+			if err != nil {
+				return err
+			}
+		case bytecode.OpCaptureLocal:
+			localIndex := bytecode.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			// the first capture moves the variable into a cell shared with the closures
+			frame := vm.currentFrame()
+			slot := frame.basePointer + int(localIndex)
+			cell, ok := vm.stack[slot].(*object.Cell)
+			if !ok {
+				cell = &object.Cell{Value: vm.stack[slot]}
+				vm.stack[slot] = cell
+			}
+			err := vm.push(cell)
+			if err != nil {
+				return err
+			}
+		case bytecode.OpCaptureFree:
+			freeIndex := bytecode.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(currentClosure.Free[freeIndex])
+			if err != nil {
+				return err
+			}
+		case bytecode.OpClosure:
+			constIndex := bytecode.ReadUint16(ins[ip+1:])
+			numFree := bytecode.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			err := vm.pushClosure(int(constIndex), int(numFree))
+			if err != nil {
+				return err
+			}
+		case bytecode.OpGetFree:
+			freeIndex := bytecode.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			value := currentClosure.Free[freeIndex].Value
+			if value == nil {
+				return undefinedVariable(currentClosure.Fn.FreeNames, int(freeIndex))
+			}
+			err := vm.push(value)
+			if err != nil {
+				return err
+			}
+		case bytecode.OpSetFree:
+			freeIndex := bytecode.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			currentClosure.Free[freeIndex].Value = vm.pop()
+		case bytecode.OpGetBuiltin:
+			builtinIndex := bytecode.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			definition := object.Builtins[builtinIndex]
+			err := vm.push(definition.Value)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
 func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize {
-		return fmt.Errorf("Stack overflow!")
+	if vm.sp >= len(vm.stack) {
+		if err := vm.growStack(vm.sp + 1); err != nil {
+			return err
+		}
 	}
 	vm.stack[vm.sp] = o
 	vm.sp++
 	return nil
 }
 
+// growStack makes room for at least size values on the stack
+func (vm *VM) growStack(size int) error {
+	if size <= len(vm.stack) {
+		return nil
+	}
+	if size > MaxStackSize {
+		return fmt.Errorf("stack overflow")
+	}
+	stack := make([]object.Object, min(max(2*len(vm.stack), size), MaxStackSize))
+	copy(stack, vm.stack[:vm.sp])
+	vm.stack = stack
+	return nil
+}
+
+// undefinedVariable is the error of reading the variable at index of names before its let
+// has run, as the evaluator reports it
+func undefinedVariable(names []string, index int) error {
+	if index < len(names) {
+		return fmt.Errorf("identifier not found: %s", names[index])
+	}
+	return fmt.Errorf("identifier not found: variable %d", index)
+}
+
+// pushAllocated pushes a string, array or hash just created by a builtin or converted from
+// another value, whose memory counts against the budget of the run. The values the VM builds
+// itself are counted before they are built.
+func (vm *VM) pushAllocated(o object.Object) error {
+	if err := vm.meter.Alloc(object.SizeOf(o)); err != nil {
+		return err
+	}
+	return vm.push(o)
+}
+
 func (vm *VM) pop() object.Object {
 	returnVal := vm.stack[vm.sp-1]
 	vm.sp--
@@ -209,6 +611,32 @@ func (vm *VM) executeComparison(op bytecode.Opcode) error {
 			return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
 		case bytecode.OpLessThan:
 			return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+		case bytecode.OpGreaterEqual:
+			return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+		case bytecode.OpLessEqual:
+			return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
+		default:
+			return fmt.Errorf("Unknown operator: %d", op)
+		}
+	}
+
+	if isNumeric(left) && isNumeric(right) {
+		leftValue := toFloat(left)
+		rightValue := toFloat(right)
+
+		switch op {
+		case bytecode.OpEqual:
+			return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+		case bytecode.OpNotEqual:
+			return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
+		case bytecode.OpGreaterThan:
+			return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+		case bytecode.OpLessThan:
+			return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+		case bytecode.OpGreaterEqual:
+			return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+		case bytecode.OpLessEqual:
+			return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
 		default:
 			return fmt.Errorf("Unknown operator: %d", op)
 		}
@@ -232,6 +660,9 @@ func (vm *VM) executeBinaryOperation(op bytecode.Opcode) error {
 	switch {
 	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case isNumeric(left) && isNumeric(right):
+		// mixed integer and float operands are promoted to float
+		return vm.executeBinaryFloatOperation(op, left, right)
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
 	default:
@@ -245,13 +676,69 @@ func (vm *VM) executeBinaryStringOperation(op bytecode.Opcode, left object.Objec
 	}
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
+	if err := vm.meter.Alloc(object.StringSize(len(leftVal) + len(rightVal))); err != nil {
+		return err
+	}
 	return vm.push(&object.String{Value: leftVal + rightVal})
 }
 
 func (vm *VM) executeBinaryIntegerOperation(op bytecode.Opcode, left, right object.Object) error {
 	leftValue := left.(*object.Integer).Value
 	rightValue := right.(*object.Integer).Value
+	if (op == bytecode.OpDiv || op == bytecode.OpMod) && rightValue == 0 {
+		return fmt.Errorf("division by zero")
+	}
+
 	var result int64
+	ok := true
+	switch op {
+	case bytecode.OpAdd:
+		result, ok = object.AddInt64(leftValue, rightValue)
+	case bytecode.OpSub:
+		result, ok = object.SubInt64(leftValue, rightValue)
+	case bytecode.OpMul:
+		result, ok = object.MulInt64(leftValue, rightValue)
+	case bytecode.OpDiv:
+		result, ok = object.DivInt64(leftValue, rightValue)
+	case bytecode.OpMod:
+		result = leftValue % rightValue
+	case bytecode.OpBitAnd:
+		result = leftValue & rightValue
+	case bytecode.OpBitOr:
+		result = leftValue | rightValue
+	case bytecode.OpBitXor:
+		result = leftValue ^ rightValue
+	case bytecode.OpShiftLeft, bytecode.OpShiftRight:
+		if rightValue < 0 {
+			return fmt.Errorf("negative shift count: %d", rightValue)
+		}
+		if op == bytecode.OpShiftLeft {
+			result, ok = object.ShlInt64(leftValue, rightValue)
+		} else {
+			result = leftValue >> rightValue
+		}
+	default:
+		return fmt.Errorf("Unknown integer operator: %d", op)
+	}
+	if vm.CheckedArithmetic && !ok {
+		return fmt.Errorf("integer overflow: %d %s %d", leftValue, integerOperators[op], rightValue)
+	}
+	return vm.push(&object.Integer{Value: result})
+}
+
+// integerOperators maps the opcodes that can overflow to their source operators, for error messages
+var integerOperators = map[bytecode.Opcode]string{
+	bytecode.OpAdd:       "+",
+	bytecode.OpSub:       "-",
+	bytecode.OpMul:       "*",
+	bytecode.OpDiv:       "/",
+	bytecode.OpShiftLeft: "<<",
+}
+
+func (vm *VM) executeBinaryFloatOperation(op bytecode.Opcode, left, right object.Object) error {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
+	var result float64
 	switch op {
 	case bytecode.OpAdd:
 		result = leftValue + rightValue
@@ -261,10 +748,26 @@ func (vm *VM) executeBinaryIntegerOperation(op bytecode.Opcode, left, right obje
 		result = leftValue * rightValue
 	case bytecode.OpDiv:
 		result = leftValue / rightValue
+	case bytecode.OpMod:
+		result = math.Mod(leftValue, rightValue)
 	default:
-		return fmt.Errorf("Unknown integer operator: %d", op)
+		return fmt.Errorf("Unknown float operator: %d", op)
 	}
-	return vm.push(&object.Integer{Value: result})
+	return vm.push(&object.Float{Value: result})
+}
+
+func isNumeric(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	}
+	return 0
 }
 
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
@@ -287,17 +790,7 @@ func isTruthy(obj object.Object) bool {
 
 func (vm *VM) executeBangOperator() error {
 	operand := vm.pop()
-	switch operand {
-	case VmTrue:
-		return vm.push(VmFalse)
-	case VmFalse:
-		return vm.push(VmTrue)
-	case VmNull:
-		return vm.push(VmTrue)
-	default:
-		return vm.push(VmFalse)
-	}
-
+	return vm.push(nativeBoolToBooleanObject(!isTruthy(operand)))
 }
 func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
 	elements := make([]object.Object, endIndex-startIndex)
@@ -326,12 +819,41 @@ func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
 	return &object.Hash{Pairs: hashedPairs}, nil
 }
 
+// buildModule creates the module object from the names and global indices of its exports
+// between startIndex and endIndex on the stack, exports the module has not defined are left out
+func (vm *VM) buildModule(name object.Object, startIndex, endIndex int) object.Object {
+	module := &object.Module{Name: name.(*object.String).Value, Exports: map[string]object.Object{}}
+	for i := startIndex; i < endIndex; i += 2 {
+		if value := vm.globals[vm.stack[i+1].(*object.Integer).Value]; value != nil {
+			module.Exports[vm.stack[i].(*object.String).Value] = value
+		}
+	}
+	return module
+}
+
 func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return vm.executeArrayIndex(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeStringIndex(left, index)
+	case left.Type() == object.ERROR_VALUE_OBJ:
+		errorValue := left.(*object.ErrorValue)
+		if err := vm.meter.Alloc(errorValue.FieldSize(index)); err != nil {
+			return err
+		}
+		if field, ok := errorValue.Field(index); ok {
+			return vm.push(field)
+		}
+		return vm.push(VmNull)
+	case left.Type() == object.MODULE_OBJ:
+		member, err := left.(*object.Module).Member(index)
+		if err != nil {
+			return err
+		}
+		return vm.push(member)
 	default:
 		return fmt.Errorf("Index operator not supported: %s", left.Type())
 	}
@@ -347,6 +869,41 @@ func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	return vm.push(arrayObj.Elements[i])
 }
 
+// buildString concatenates the strings between startIndex and endIndex on the stack, their
+// memory is counted before the result is built
+func (vm *VM) buildString(startIndex, endIndex int) (object.Object, error) {
+	length := 0
+	for i := startIndex; i < endIndex; i++ {
+		str, ok := vm.stack[i].(*object.String)
+		if !ok {
+			return nil, fmt.Errorf("cannot concatenate %s", vm.stack[i].Type())
+		}
+		length += len(str.Value)
+	}
+	if err := vm.meter.Alloc(object.StringSize(length)); err != nil {
+		return nil, err
+	}
+
+	var out strings.Builder
+	out.Grow(length)
+	for i := startIndex; i < endIndex; i++ {
+		out.WriteString(vm.stack[i].(*object.String).Value)
+	}
+	return &object.String{Value: out.String()}, nil
+}
+
+// executeStringIndex indexes strings by code point, not by byte
+func (vm *VM) executeStringIndex(str, index object.Object) error {
+	r, ok := object.RuneAt(str.(*object.String).Value, index.(*object.Integer).Value)
+	if !ok {
+		return vm.push(VmNull)
+	}
+	if err := vm.meter.Alloc(object.StringSize(utf8.RuneLen(r))); err != nil {
+		return err
+	}
+	return vm.push(&object.String{Value: string(r)})
+}
+
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	hashObj := hash.(*object.Hash)
 	key, ok := index.(object.Hashable)
@@ -360,16 +917,138 @@ func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	return vm.push(pair.Value)
 }
 
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("array index must be INTEGER, got %s", index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return fmt.Errorf("array index out of range: %d", i.Value)
+		}
+		left.Elements[i.Value] = value
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		if _, ok := left.Pairs[key.HashKey()]; !ok {
+			if err := vm.meter.Alloc(object.HashPairSize); err != nil {
+				return err
+			}
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+	return vm.push(value)
+}
+
+// currentPosition returns the source position of the instruction currently executed
+func (vm *VM) currentPosition() token.Position {
+	if vm.framesIndex < 1 {
+		return token.Position{}
+	}
+	frame := vm.currentFrame()
+	return frame.cl.Fn.SourceMap.PositionAt(frame.ip)
+}
+
 func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
 func (vm *VM) pushFrame(f *Frame) {
-	vm.frames[vm.framesIndex] = f
+	if vm.framesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, f)
+	} else {
+		vm.frames[vm.framesIndex] = f
+	}
 	vm.framesIndex++
 }
 
 func (vm *VM) popFrame() *Frame {
+	vm.meter.Leave()
 	vm.framesIndex--
 	return vm.frames[vm.framesIndex]
 }
+
+func (vm *VM) callFunction(numArgs int) error {
+	// the arguments sit on top of the stack, the callee right below them
+	switch callee := vm.stack[vm.sp-1-numArgs].(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("calling non-function")
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+	if err := vm.meter.Enter(); err != nil {
+		return err
+	}
+
+	frame := NewFrame(cl, vm.sp-numArgs)
+	if err := vm.growStack(frame.basePointer + cl.Fn.NumLocals); err != nil {
+		vm.meter.Leave()
+		return err
+	}
+	vm.pushFrame(frame)
+	// slots of locals may still hold cells of a previous call, which must not be written through
+	for i := vm.sp; i < frame.basePointer+cl.Fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+	if builtin.ResultSize != nil {
+		if err := vm.meter.Alloc(builtin.ResultSize(args...)); err != nil {
+			return err
+		}
+	}
+
+	result := builtin.Call(vm.Sandbox, args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	if err, ok := result.(*object.Error); ok {
+		return &thrownError{err: err}
+	}
+
+	if result != nil && builtin.Created(result, args) {
+		return vm.pushAllocated(result)
+	}
+	if result != nil {
+		return vm.push(result)
+	}
+	return vm.push(VmNull)
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]*object.Cell, numFree)
+	for i := 0; i < numFree; i++ {
+		cell, ok := vm.stack[vm.sp-numFree+i].(*object.Cell)
+		if !ok {
+			// the function itself, which can't be assigned to, is captured as a plain value
+			cell = &object.Cell{Value: vm.stack[vm.sp-numFree+i]}
+		}
+		free[i] = cell
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
@@ -1,13 +1,21 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"monkey-int/ast"
+	"monkey-int/bytecode"
 	"monkey-int/compiler"
 	"monkey-int/lexer"
 	"monkey-int/object"
 	"monkey-int/parser"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func parse(input string) *ast.Program {
@@ -65,6 +73,15 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case float64:
+		result, ok := actual.(*object.Float)
+		if !ok {
+			t.Errorf("Object is not a Float: %T (%+v)", actual, actual)
+			return
+		}
+		if result.Value != expected {
+			t.Errorf("Object has wrong value. Wanted=%g, got=%g instead.", expected, result.Value)
+		}
 	case bool:
 		err := testBooleanObject(bool(expected), actual)
 		if err != nil {
@@ -95,6 +112,15 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 				t.Errorf("testIntegerObject failed: %s", err)
 			}
 		}
+	case *object.Error:
+		errObj, ok := actual.(*object.Error)
+		if !ok {
+			t.Errorf("Object is not Error: %T (%+v)", actual, actual)
+			return
+		}
+		if errObj.Message != expected.Message {
+			t.Errorf("Wrong error message. Wanted=%q, got=%q instead.", expected.Message, errObj.Message)
+		}
 	case map[object.HashKey]int64:
 		hash, ok := actual.(*object.Hash)
 		if !ok {
@@ -271,6 +297,865 @@ func TestIndexExpressions(t *testing.T) {
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", VmNull},
 		{"{}[0]", VmNull},
+		{"{1.0: 1}[1]", 1},
+		{"{1: 1}[1.0]", 1},
+		{"{1.5: 1}[1]", VmNull},
+		{`"héllo"[1]`, "é"},
+		{`"😀!"[1]`, "!"},
+		{`"abc"[3]`, VmNull},
+	}
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctionsWithoutArguments(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let fivePlusTen = fn() { 5 + 10; };
+			fivePlusTen();
+			`,
+			expected: 15,
+		},
+		{
+			input: `
+			let one = fn() { 1; };
+			let two = fn() { 2; };
+			one() + two()
+			`,
+			expected: 3,
+		},
+		{
+			input: `
+			let a = fn() { 1 };
+			let b = fn() { a() + 1 };
+			let c = fn() { b() + 1 };
+			c();
+			`,
+			expected: 3,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestFunctionsWithReturnStatement(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let earlyExit = fn() { return 99; 100; };
+			earlyExit();
+			`,
+			expected: 99,
+		},
+		{
+			input: `
+			let earlyExit = fn() { return 99; return 100; };
+			earlyExit();
+			`,
+			expected: 99,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestTopLevelReturnStatement(t *testing.T) {
+	tests := []vmTestCase{
+		{"return 5;", 5},
+		{"return 5; 6;", 5},
+		{"let x = 1; if (x > 0) { return x + 1; } 10;", 2},
+		{"let f = fn() { return 1; }; for (x in [1, 2, 3]) { if (x == 2) { return f() + x; } } 0;", 3},
+		{"try { return 7; } catch (e) { 8 }; 9;", 7},
+	}
+	runVmTests(t, tests)
+}
+
+func TestFunctionsWithoutReturnValue(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let noReturn = fn() { };
+			noReturn();
+			`,
+			expected: VmNull,
+		},
+		{
+			input: `
+			let noReturn = fn() { };
+			let noReturnTwo = fn() { noReturn(); };
+			noReturn();
+			noReturnTwo();
+			`,
+			expected: VmNull,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestFirstClassFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let returnsOne = fn() { 1; };
+			let returnsOneReturner = fn() { returnsOne; };
+			returnsOneReturner()();
+			`,
+			expected: 1,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestCallingNonFunction(t *testing.T) {
+	program := parse("1()")
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("Expected VM error but resulted in none.")
+	}
+	if err.Error() != "1:2: calling non-function" {
+		t.Fatalf("Wrong VM error. Wanted=%q, got=%q instead.", "1:2: calling non-function", err)
+	}
+}
+
+func TestCallingFunctionsWithBindings(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let one = fn() { let one = 1; one };
+			one();
+			`,
+			expected: 1,
+		},
+		{
+			input: `
+			let oneAndTwo = fn() { let one = 1; let two = 2; one + two; };
+			oneAndTwo();
+			`,
+			expected: 3,
+		},
+		{
+			input: `
+			let firstFoobar = fn() { let foobar = 50; foobar; };
+			let secondFoobar = fn() { let foobar = 100; foobar; };
+			firstFoobar() + secondFoobar();
+			`,
+			expected: 150,
+		},
+		{
+			input: `
+			let globalSeed = 50;
+			let minusOne = fn() { let num = 1; globalSeed - num; };
+			let minusTwo = fn() { let num = 2; globalSeed - num; };
+			minusOne() + minusTwo();
+			`,
+			expected: 97,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctionsWithArgumentsAndBindings(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let identity = fn(a) { a; };
+			identity(4);
+			`,
+			expected: 4,
+		},
+		{
+			input: `
+			let add = fn(a, b) { let c = a + b; c };
+			add(1, 2);
+			`,
+			expected: 3,
+		},
+		{
+			input: `
+			let sum = fn(a, b) { let c = a + b; c; };
+			let outer = fn() { sum(1, 2) + sum(3, 4); };
+			outer();
+			`,
+			expected: 10,
+		},
+		{
+			input: `
+			let globalNum = 10;
+			let sum = fn(a, b) { let c = a + b; c + globalNum; };
+			let outer = fn() { sum(1, 2) + sum(3, 4) + globalNum; };
+			outer() + globalNum;
+			`,
+			expected: 50,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctionsWithWrongArguments(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input:    `fn() { 1; }(1);`,
+			expected: `1:12: wrong number of arguments: want=0, got=1`,
+		},
+		{
+			input:    `fn(a) { a; }();`,
+			expected: `1:13: wrong number of arguments: want=1, got=0`,
+		},
+		{
+			input:    `fn(a, b) { a + b; }(1);`,
+			expected: `1:20: wrong number of arguments: want=2, got=1`,
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("Compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("Expected VM error but resulted in none.")
+		}
+		if err.Error() != tt.expected {
+			t.Fatalf("Wrong VM error. Wanted=%q, got=%q instead.", tt.expected, err)
+		}
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let newClosure = fn(a) { fn() { a; }; };
+			let closure = newClosure(99);
+			closure();
+			`,
+			expected: 99,
+		},
+		{
+			input: `
+			let newAdder = fn(a, b) { fn(c) { a + b + c }; };
+			let adder = newAdder(1, 2);
+			adder(8);
+			`,
+			expected: 11,
+		},
+		{
+			input: `
+			let newAdderOuter = fn(a, b) {
+				let c = a + b;
+				fn(d) {
+					let e = d + c;
+					fn(f) { e + f; };
+				};
+			};
+			let newAdderInner = newAdderOuter(1, 2)
+			let adder = newAdderInner(3);
+			adder(8);
+			`,
+			expected: 14,
+		},
+		{
+			input: `
+			let newClosure = fn(a, b) {
+				let one = fn() { a; };
+				let two = fn() { b; };
+				fn() { one() + two(); };
+			};
+			let closure = newClosure(9, 90);
+			closure();
+			`,
+			expected: 99,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let countDown = fn(x) {
+				if (x == 0) {
+					return 0;
+				} else {
+					countDown(x - 1);
+				}
+			};
+			countDown(1);
+			`,
+			expected: 0,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) {
+					if (x == 0) {
+						return 0;
+					} else {
+						countDown(x - 1);
+					}
+				};
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expected: 0,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFibonacci(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let fibonacci = fn(x) {
+				if (x == 0) {
+					return 0;
+				} else {
+					if (x == 1) {
+						return 1;
+					} else {
+						fibonacci(x - 1) + fibonacci(x - 2);
+					}
+				}
+			};
+			fibonacci(15);
+			`,
+			expected: 610,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("hello world")`, 11},
+		{`len("héllo")`, 5},
+		{`len([1, 2, 3])`, 3},
+		{`len([])`, 0},
+		{`puts("hello", "world!")`, VmNull},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, VmNull},
+		{`last([1, 2, 3])`, 3},
+		{`last([])`, VmNull},
+		{`tail([1, 2, 3])`, []int{2, 3}},
+		{`tail([])`, VmNull},
+		{`push([], 1)`, []int{1}},
+		{`let l = fn(arr) { len(arr) }; l([1, 2])`, 2},
+	}
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrorPositions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input:    "let a = 1;\nlet b = a + true;",
+			expected: "script.mk:2:11: Unsupported types for binary operation: MONKEY_INT MONKEY_BOOL",
+		},
+		{
+			input:    "let f = fn() {\n  -true\n};\nf();",
+			expected: "script.mk:2:3: Unsupported type for negation: MONKEY_BOOL",
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewWithFile("script.mk", tt.input)
+		program := parser.New(l).ParseProgram()
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("Compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("Expected VM error but resulted in none.")
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("Wrong VM error. Wanted=%q, got=%q instead.", tt.expected, err)
+		}
+	}
+}
+
+func TestBuiltinErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"\nlen(1)", "2:4: argument to `len` not supported, got MONKEY_INT"},
+		{`len("one", "two")`, "1:4: wrong number of arguments. got=2, want=1"},
+		{`first(1)`, "1:6: argument to `first` must be MONKEY_ARRAY, got MONKEY_INT"},
+		{`last(1)`, "1:5: argument to `last` must be MONKEY_ARRAY, got MONKEY_INT"},
+		{`push(1, 1)`, "1:5: argument to `push` must be MONKEY_ARRAY, got MONKEY_INT"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("Compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("Expected VM error but resulted in none.")
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("Wrong VM error. Wanted=%q, got=%q instead.", tt.expected, err)
+		}
+	}
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"1 / 4.0", 0.25},
+		{"10 - 0.5", 9.5},
+		{"1e2 + 1", 101.0},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"1.5 != 1.5", false},
+		{"{1.5: 2}[1.5]", 2},
+	}
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"while (false) { 1 }; 3", 3},
+		{"let f = fn() { while (true) { return 5; } }; f()", 5},
+		{"let f = fn() { while (true) { break; } 7 }; f()", 7},
+		{"let f = fn() { for (x in [1, 2, 3, 4]) { if (x == 3) { return x; } } }; f()", 3},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x < 2) { continue; } return x; } }; f()", 2},
+		{"let f = fn() { for (x in []) { return 1; } 2 }; f()", 2},
+		{"let f = fn() { for (k in {2: 1, 1: 2}) { return k; } }; f()", 1},
+		{`for (c in "abc") { }; c`, "c"},
+		{"let f = fn() { for (x in [1, 2]) { for (y in [3, 4]) { break; } return x + 10; } }; f()", 11},
+		{"if (true) { let a = 1; }", VmNull},
+		{"if (false) { 1 } else { }", VmNull},
+	}
+	runVmTests(t, tests)
+}
+
+func TestBreakAndContinueInExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (i < 10000) { i += 1; let a = [100, if (true) { continue }]; }; i", 10000},
+		{"let f = fn(a, b) { a }; let i = 0; while (true) { i += 1; f(1, 2 + if (i == 10000) { break } else { 3 }); }; i", 10000},
+		{"let n = 0; for (x in [1, 2, 3]) { n = n + {1: x * if (x == 2) { continue } else { 1 }}[1]; }; n", 4},
+		{"let f = fn() { let i = 0; while (i < 10000) { i += 1; [1, [2, i > 0 && if (true) { continue }]]; } i }; f()", 10000},
+	}
+	runVmTests(t, tests)
+
+	// the operands pushed before a break or continue must not pile up on the stack
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("Compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("VM Error: %s", err)
+		}
+		if vm.sp != 0 {
+			t.Errorf("%q: values left on the stack. Wanted=0, got=%d instead.", tt.input, vm.sp)
+		}
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 1; a = 2; a", 2},
+		{"let a = 1; a += 2; a *= 4; a -= 2; a /= 5; a", 2},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		{"let a = 1; a = 5", 5},
+		{"let a = 1; let a = 2; a", 2},
+		{"let s = 0; for (x in [1, 2, 3]) { s += x }; s", 6},
+		{"let i = 0; while (i < 10) { i += 1 }; i", 10},
+		{"let f = fn() { let a = 1; a += 1; a }; f()", 2},
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()", 3},
+		{"let arr = [1, 2, 3]; arr[1] = 5; arr", []int{1, 5, 3}},
+		{"let arr = [1, 2, 3]; arr[2] *= 3; arr[2]", 9},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] += 10; h["a"] + h["b"]`, 13},
+		// the container and index of a compound assignment are evaluated once
+		{"let i = 0; let next = fn() { i += 1; i - 1 }; let a = [1, 2]; a[next()] += 10; [i, a[0], a[1]]", []int{1, 11, 2}},
+		{"let calls = 0; let m = [[1]]; let g = fn() { calls += 1; m }; g()[0][0] += 1; [calls, m[0][0]]", []int{1, 2}},
+		{"let f = fn() { for (x in [1, 2]) { for (y in [3, 4]) { } } x + y }; f()", 6},
+		// closures share captured variables with the function defining them
+		{"let mk = fn() { let c = 0; let inc = fn() { c += 1; c }; inc(); inc(); c }; mk()", 2},
+		{"let f = fn() { let x = 1; let g = fn() { x = 2 }; g(); x }; f()", 2},
+		{"let f = fn() { let x = 1; let g = fn() { fn() { x = 5 } }; g()(); x }; f()", 5},
+		{"let pair = fn() { let n = 0; [fn() { n += 1 }, fn() { n }] }; let p = pair(); p[0](); p[0](); p[1]()", 2},
+		{"let f = fn(v) { fn() { v } }; let one = f(1); let two = f(2); one() + two()", 3},
+		{"let mk = fn() { let c = 0; fn() { c } }; let k = mk(); let h = fn() { let z = 5; z }; h(); k()", 0},
+	}
+	runVmTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"let arr = [1]; arr[3] = 1", "1:23: array index out of range: 3"},
+		{"let a = 1; a[0] = 1", "1:17: index assignment not supported: MONKEY_INT"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("Compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("Expected VM error but resulted in none.")
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("Wrong VM error. Wanted=%q, got=%q instead.", tt.expected, err)
+		}
+	}
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []vmTestCase{
+		{`let name = "Ann"; let age = 41; "hello ${name}, you are ${age + 1}"`, "hello Ann, you are 42"},
+		{`"${1.5} ${true} ${[1, "a"]} ${if (false) { 1 }}"`, "1.5 true [1, a] null"},
+		{`"a ${"b ${1 + 1}"} c"`, "a b 2 c"},
+		{`let f = fn(x) { "<${x}>" }; f(1) + f("y")`, "<1><y>"},
+		{`"${""}"`, ""},
+	}
+	runVmTests(t, tests)
+}
+
+func TestOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"1 << 4", 16},
+		{"-16 >> 2", -4},
+		{"7.5 % 2", 1.5},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"2 >= 2", true},
+		{"1.5 >= 2", false},
+		{"true && false", false},
+		{"true && 1", true},
+		{"false || 0", true},
+		{"false || false", false},
+		{"if (1 > 2 || 2 > 1) { 10 } else { 20 }", 10},
+		{"let n = 0; let inc = fn() { n += 1; true }; false && inc(); true || inc(); n", 0},
+		{"let n = 0; let inc = fn() { n += 1; true }; true && inc(); false || inc(); n", 2},
 	}
 	runVmTests(t, tests)
 }
+
+func TestArithmeticErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		checked  bool
+		expected string
+	}{
+		{"1 / 0", false, "1:3: division by zero"},
+		{"1 % 0", false, "1:3: division by zero"},
+		{"let a = 5; a /= 0", false, "1:14: division by zero"},
+		{"9223372036854775807 + 1", true, "1:21: integer overflow: 9223372036854775807 + 1"},
+		{"-9223372036854775807 - 2", true, "1:22: integer overflow: -9223372036854775807 - 2"},
+		{"4611686018427387904 * 2", true, "1:21: integer overflow: 4611686018427387904 * 2"},
+		{"-(-9223372036854775807 - 1)", true, "1:1: integer overflow: --9223372036854775808"},
+		{"(-9223372036854775807 - 1) / -1", true, "1:28: integer overflow: -9223372036854775808 / -1"},
+		{"1 << 63", true, "1:3: integer overflow: 1 << 63"},
+		{"let f = fn(x) { x * x }; f(3037000500)", true, "1:19: integer overflow: 3037000500 * 3037000500"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("Compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.CheckedArithmetic = tt.checked
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("%s: expected VM error but resulted in none.", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("Wrong VM error. Wanted=%q, got=%q instead.", tt.expected, err)
+		}
+	}
+
+	unchecked := []vmTestCase{
+		{"9223372036854775807 + 1", math.MinInt64},
+		{"1.0 / 0", math.Inf(1)},
+	}
+	runVmTests(t, unchecked)
+}
+
+func TestInternalErrors(t *testing.T) {
+	program := parse("let f = fn() { 1 };\nf();")
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+
+	// corrupt the bytecode of f so it loads a constant that does not exist
+	myBytecode := comp.Bytecode()
+	for _, constant := range myBytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			fn.Instructions = append(bytecode.Make(bytecode.OpConstant, 999), bytecode.Make(bytecode.OpReturnValue)...)
+		}
+	}
+
+	vm := New(myBytecode)
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("Expected VM error but resulted in none.")
+	}
+	if !strings.HasPrefix(err.Error(), "1:16: internal error: runtime error: index out of range") {
+		t.Errorf("Wrong VM error. Got=%q", err)
+	}
+
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("Error is not a *RuntimeError. Got=%T", err)
+	}
+	expectedStack := "\tat f (1:16)\n\tat <main> (2:2)\n"
+	if stack := object.FormatStack(runtimeErr.Stack); stack != expectedStack {
+		t.Errorf("Wrong stack. Wanted=%q, got=%q instead.", expectedStack, stack)
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []vmTestCase{
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { } catch (e) { 2 }`, VmNull},
+		{`try { 1 / 0 } catch (e) { e["message"] }`, "division by zero"},
+		{`try { 1 / 0 } catch (e) { e["kind"] }`, "RuntimeError"},
+		{`try { len(1) } catch (e) { e["message"] }`, "argument to `len` not supported, got MONKEY_INT"},
+		{`try { throw("boom") } catch (e) { e["message"] + " " + e["kind"] }`, "boom Error"},
+		{`try { throw("bad", "ValueError") } catch (e) { e["kind"] }`, "ValueError"},
+		{`try { throw([1, 2]) } catch (e) { e["message"] }`, "[1, 2]"},
+		{`try { throw("x") } catch (e) { "${e}" }`, "Error: x"},
+		{`try { throw("x") } catch (e) { e["other"] }`, VmNull},
+		{`try { try { 1 / 0 } catch (e) { throw(e) } } catch (e) { e["message"] }`, "division by zero"},
+		{`1 + try { 2 + [3, 4 / 0][0] } catch (e) { 10 }`, 11},
+		{`let f = fn(a) { let b = 2; a + try { b * throw("x") } catch (e) { b } }; f(1)`, 3},
+		{`let n = 0; for (x in [1, 0, 2]) { n += try { 10 / x } catch (e) { 100 } }; n`, 115},
+		{`let f = fn() { try { return 1 } catch (e) { 2 }; 3 }; f()`, 1},
+		{`let n = 0; while (true) { try { n += 1; if (n == 3) { break } } catch (e) { } }; n`, 3},
+		{`let f = fn(x) { if (x == 0) { throw("done") } f(x - 1) }; try { f(10) } catch (e) { len(e["stack"]) }`, 12},
+	}
+	runVmTests(t, tests)
+
+	stacks := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn() { throw(\"x\") };\ntry { f() } catch (e) { e[\"stack\"] }", "[at f (1:21), at <main> (2:8)]"},
+		{"let f = fn() { 1 / 0 };\nlet g = fn() { try { f() } catch (e) { e[\"stack\"] } };\ng()", "[at f (1:18), at g (2:23)]"},
+	}
+	for _, tt := range stacks {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("Compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("VM Error: %s", err)
+		}
+		if stack := vm.LastPoppedStackElem().Inspect(); stack != tt.expected {
+			t.Errorf("Wrong stack. Wanted=%q, got=%q instead.", tt.expected, stack)
+		}
+	}
+
+	program := parse(`try { throw("a") } catch (e) { throw("b") }`)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	err = New(comp.Bytecode()).Run()
+	if err == nil || err.Error() != "1:37: b" {
+		t.Errorf("Wrong VM error. Got=%v", err)
+	}
+}
+
+// writeModules writes the module files used by the import tests to a new directory
+func writeModules(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"lib.mk":    "import \"util.mk\" as u\nlet count = 0;\nlet inc = fn() { count += 1; count };\nlet twice = fn(x) { u[\"double\"](x) };",
+		"util.mk":   "let double = fn(x) { x * 2 };",
+		"early.mk":  "let a = 1;\nif (a == 1) { return 0 }\nlet b = 2;",
+		"cycle1.mk": `import "cycle2.mk" as c`,
+		"cycle2.mk": `import "cycle1.mk" as c`,
+		"bad.mk":    "let f = fn() { 1 / 0 };\nf();",
+		"syntax.mk": "let = 1;",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatalf("could not write module: %s", err)
+		}
+	}
+	return dir
+}
+
+func TestImports(t *testing.T) {
+	dir := writeModules(t)
+
+	tests := []vmTestCase{
+		{`import "lib.mk" as l; l["twice"](21)`, 42},
+		{`import "lib.mk" as a; import "lib.mk" as b; a["inc"](); b["inc"]()`, 2},
+		{`import "lib.mk" as a; import "lib.mk" as b; a == b`, true},
+		{`import "lib.mk" as l; l["count"]`, 0},
+		{`import "util.mk" as u; let double = 5; u["double"](double)`, 10},
+		{`import "early.mk" as e; e["a"]`, 1},
+	}
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parser.New(lexer.NewWithFile(filepath.Join(dir, "main.mk"), tt.input)).ParseProgram())
+		if err != nil {
+			t.Fatalf("Compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("VM Error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+
+	errorTests := []struct {
+		input         string
+		expectedError string
+	}{
+		{`import "early.mk" as e; e["b"]`, `module early.mk has no member "b"`},
+		{`import "lib.mk" as l; l[1]`, "module member must be MONKEY_STRING, got MONKEY_INT"},
+		{`import "cycle1.mk" as c`, "import cycle: " + filepath.Join(dir, "cycle1.mk") + " -> " + filepath.Join(dir, "cycle2.mk") + " -> " + filepath.Join(dir, "cycle1.mk")},
+		{`import "syntax.mk" as s`, `could not import "syntax.mk": ` + filepath.Join(dir, "syntax.mk") + `:1:5: expected identifier, found "="`},
+		{`import "missing.mk" as m`, `could not import "missing.mk"`},
+	}
+	for _, tt := range errorTests {
+		comp := compiler.New()
+		err := comp.Compile(parser.New(lexer.NewWithFile(filepath.Join(dir, "main.mk"), tt.input)).ParseProgram())
+		if err == nil {
+			err = New(comp.Bytecode()).Run()
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+			t.Errorf("%s: wrong error. Wanted=%q, got=%v instead.", tt.input, tt.expectedError, err)
+		}
+	}
+
+	comp := compiler.New()
+	err := comp.Compile(parser.New(lexer.NewWithFile(filepath.Join(dir, "main.mk"), `import "bad.mk" as b`)).ParseProgram())
+	if err != nil {
+		t.Fatalf("Compiler error: %s", err)
+	}
+	var runtimeErr *RuntimeError
+	if err := New(comp.Bytecode()).Run(); !errors.As(err, &runtimeErr) {
+		t.Fatalf("Expected a runtime error, got %v", err)
+	}
+	expected := "\tat f (" + filepath.Join(dir, "bad.mk") + ":1:18)\n\tat <module bad.mk> (" + filepath.Join(dir, "bad.mk") +
+		":2:2)\n\tat <main> (" + filepath.Join(dir, "main.mk") + ":1:1)\n"
+	if stack := object.FormatStack(runtimeErr.Stack); stack != expected {
+		t.Errorf("Wrong stack. Wanted=%q, got=%q instead.", expected, stack)
+	}
+}
+
+func TestBudgets(t *testing.T) {
+	recursion := "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } };"
+	// doubled defines s as a string of 2^(n+1) characters
+	doubled := func(n int) string {
+		return fmt.Sprintf(`let s = "ab"; let i = 0; while (i < %d) { s = s + s; i = i + 1 };`, n)
+	}
+	locals := "let g = fn(n) { let a = 1; let b = 2; let c = 3; if (n == 0) { a + b + c } else { g(n - 1) } };"
+	tests := []struct {
+		input     string
+		budget    object.Budget
+		cancelled bool
+		expected  string // error message, empty if the run succeeds
+	}{
+		{"while (true) { }", object.Budget{MaxSteps: 1000}, false, "budget exceeded: more than 1000 steps"},
+		{"let f = fn() { f() }; f()", object.Budget{}, false, "budget exceeded: call depth exceeds 1000"},
+		{recursion + "f(50)", object.Budget{MaxCallDepth: 10}, false, "budget exceeded: call depth exceeds 10"},
+		{recursion + "f(5)", object.Budget{MaxCallDepth: 10}, false, ""},
+		{recursion + "f(2000)", object.Budget{MaxCallDepth: 5000}, false, ""},
+		{locals + "g(998)", object.Budget{}, false, ""},
+		{locals + "g(1000)", object.Budget{}, false, "budget exceeded: call depth exceeds 1000"},
+		{"try { while (true) { } } catch (e) { 1 }", object.Budget{MaxSteps: 1000}, false, "budget exceeded: more than 1000 steps"},
+		{"while (true) { }", object.Budget{Timeout: 10 * time.Millisecond}, false, "budget exceeded: time limit of 10ms"},
+		{"1", object.Budget{}, true, "budget exceeded: context canceled"},
+		{`let s = "ab"; while (true) { s = s + s }`, object.Budget{MaxMemory: 1 << 20}, false, "budget exceeded: more than 1048576 bytes allocated"},
+		{`let s = "ab"; while (true) { s = "${s}${s}" }`, object.Budget{MaxMemory: 1 << 20}, false, "budget exceeded: more than 1048576 bytes allocated"},
+		{"let a = []; while (true) { a = push(a, 1) }", object.Budget{MaxMemory: 100000}, false, "budget exceeded: more than 100000 bytes allocated"},
+		{"let h = {}; let i = 0; while (true) { h[i] = i; i = i + 1 }", object.Budget{MaxMemory: 100000}, false, "budget exceeded: more than 100000 bytes allocated"},
+		{"while (true) { [1, 2, 3] }", object.Budget{MaxMemory: 100000}, false, "budget exceeded: more than 100000 bytes allocated"},
+		{`try { let s = "ab"; while (true) { s = s + s } } catch (e) { 1 }`, object.Budget{MaxMemory: 1 << 20}, false, "budget exceeded: more than 1048576 bytes allocated"},
+		{`let a = [1, 2, 3]; let h = {"a": a}; h["b"] = "c" + "d"`, object.Budget{MaxMemory: 1000}, false, ""},
+		// values returned by builtins are only counted if the builtin created them
+		{doubled(17) + "let a = [s]; first(a); first(a); first(a); last(a); last(a)", object.Budget{MaxMemory: 1 << 20}, false, ""},
+		{doubled(17) + "let a = [s]; tail(a); tail(a); len(s)", object.Budget{MaxMemory: 1 << 20}, false, ""},
+		{doubled(13) + "for (c in s) { }", object.Budget{MaxMemory: 100000}, false, "budget exceeded: more than 100000 bytes allocated"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("Compiler error: %s", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		if tt.cancelled {
+			cancel()
+		}
+		err := New(comp.Bytecode()).RunWithBudget(ctx, tt.budget)
+		cancel()
+
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %s", tt.input, err)
+			}
+			continue
+		}
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Errorf("%q: expected a *RuntimeError, got=%v", tt.input, err)
+			continue
+		}
+		if runtimeErr.Err.Error() != tt.expected {
+			t.Errorf("%q: wrong error message. Wanted=%q, got=%q", tt.input, tt.expected, runtimeErr.Err)
+		}
+		if !errors.Is(err, object.ErrBudgetExceeded) {
+			t.Errorf("%q: error does not wrap ErrBudgetExceeded", tt.input)
+		}
+	}
+}